@@ -2,6 +2,8 @@ from aws_cdk import (
     Duration,
     RemovalPolicy,
     Stack,
+    aws_events as events,
+    aws_events_targets as events_targets,
     aws_s3 as s3,
     aws_ses as ses,
     aws_ses_actions as ses_actions,
@@ -31,11 +33,16 @@ class QRGeneratorStack(Stack):
         # the RuleSet default-rule-set must exist and be the active one
         rule_set = ses.ReceiptRuleSet.from_receipt_rule_set_name(self, "RuleSet",
                                                                  receipt_rule_set_name="default-rule-set")
+        # the whole domain is accepted, so the sub-addresses selecting modes (qr+svg@domain) are delivered too. The
+        # lambda drops the emails sent to any other address (QR_RECIPIENTS) or mode
+        ses_domain = ses_recipient.split("@")[-1]
         rule_set.add_rule("QREmail", actions=[ses_actions.S3(bucket=emails, topic=notifications)],
-                          recipients=[ses_recipient])
+                          recipients=[ses_domain])
 
         # bucket to store files
         files = qr_website.files_bucket
+        # private bucket to store the records of the published files (to delete them when they expire)
+        records = s3.Bucket(self, "Records", block_public_access=s3.BlockPublicAccess.BLOCK_ALL)
 
         # lambda to process notifications
         qr_app = lambda_go.GoFunction(self, "QRApp", entry="qrapp/cmd",
@@ -46,6 +53,8 @@ class QRGeneratorStack(Stack):
                                           go_build_flags=["-ldflags \"-s -w\""]),
                                       environment={
                                           "FILES_BUCKET": files.bucket_name,
+                                          "RECORDS_BUCKET": records.bucket_name,
+                                          "QR_RECIPIENTS": ses_recipient,
                                       },
                                       log_retention=logs.RetentionDays.ONE_DAY,
                                       timeout=Duration.seconds(30))
@@ -53,8 +62,23 @@ class QRGeneratorStack(Stack):
         # adjust permissions
         emails.grant_read_write(qr_app.role)
         files.grant_read_write(qr_app.role)
+        records.grant_read_write(qr_app.role)
         qr_app.add_to_role_policy(iam.PolicyStatement(
             actions=["ses:SendRawEmail"],
             effect=iam.Effect.ALLOW,
             resources=[qr_ses_identity]
         ))
+
+        # lambda to delete the expired files every hour
+        cleanup = lambda_go.GoFunction(self, "QRCleanup", entry="qrapp/cmd/cleanup",
+                                       bundling=lambda_go.BundlingOptions(go_build_flags=["-ldflags \"-s -w\""]),
+                                       environment={
+                                           "FILES_BUCKET": files.bucket_name,
+                                           "RECORDS_BUCKET": records.bucket_name,
+                                       },
+                                       log_retention=logs.RetentionDays.ONE_DAY,
+                                       timeout=Duration.minutes(5))
+        events.Rule(self, "QRCleanupSchedule", schedule=events.Schedule.rate(Duration.hours(1)),
+                    targets=[events_targets.LambdaFunction(cleanup)])
+        files.grant_read_write(cleanup.role)
+        records.grant_read_write(cleanup.role)
@@ -0,0 +1,122 @@
+package qrapp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+)
+
+// SenderAllowlist is the list of senders authorized to publish files. Every entry is an address (jorge@larix.cl), a
+// domain (larix.cl) or a wildcard domain (*.larix.cl, matching any subdomain of larix.cl).
+type SenderAllowlist struct {
+	entries []string
+}
+
+// NewSenderAllowlist creates a SenderAllowlist with the given entries, ignoring the empty ones.
+func NewSenderAllowlist(entries []string) *SenderAllowlist {
+	sa := &SenderAllowlist{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry != "" {
+			sa.entries = append(sa.entries, entry)
+		}
+	}
+	return sa
+}
+
+// ParseSenderAllowlist reads a SenderAllowlist with an entry per line. Empty lines and lines starting with # are
+// ignored.
+func ParseSenderAllowlist(r io.Reader) (*SenderAllowlist, error) {
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewSenderAllowlist(entries), nil
+}
+
+// LoadSenderAllowlist reads a SenderAllowlist (see ParseSenderAllowlist) from an object in a bucket.
+func LoadSenderAllowlist(ctx context.Context, storage Storage, bucket, key string) (*SenderAllowlist, error) {
+	tmpFile, err := storage.DownloadToTmpFile(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.RemoveTmpFile(ctx, tmpFile)
+	sa, err := ParseSenderAllowlist(tmpFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read sender allowlist: %s", err)
+	}
+	return sa, nil
+}
+
+// LoadConfiguredSenderAllowlist returns the SenderAllowlist configured with comma separated entries or, if empty, an
+// object (bucket/key, see LoadSenderAllowlist). Without any of them, it returns nil: everybody is allowed.
+func LoadConfiguredSenderAllowlist(ctx context.Context, storage Storage, entries, object string) (*SenderAllowlist, error) {
+	if entries != "" {
+		return NewSenderAllowlist(strings.Split(entries, ",")), nil
+	}
+	if object != "" {
+		bucket, key, ok := strings.Cut(object, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid sender allowlist object %q (bucket/key)", object)
+		}
+		return LoadSenderAllowlist(ctx, storage, bucket, key)
+	}
+	return nil, nil
+}
+
+// Allowed checks if the address (with or without display name) matches any entry of the allowlist.
+func (sa *SenderAllowlist) Allowed(address string) bool {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return false
+	}
+	email := strings.ToLower(addr.Address)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, entry := range sa.entries {
+		switch {
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(domain, entry[1:]) {
+				return true
+			}
+		case strings.Contains(entry, "@"):
+			if email == entry {
+				return true
+			}
+		default:
+			if domain == entry {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unauthorizedSender returns the first sender of the email not matching the allowlist, checking the return path and
+// every From address. It returns an empty string if all of them are allowed.
+func (sa *SenderAllowlist) unauthorizedSender(meta *EmailMetadata) string {
+	if sa == nil {
+		return ""
+	}
+	senders := append([]string{meta.ReturnPath}, meta.From...)
+	for _, sender := range senders {
+		if !sa.Allowed(sender) {
+			return sender
+		}
+	}
+	return ""
+}
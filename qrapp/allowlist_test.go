@@ -0,0 +1,123 @@
+package qrapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderAllowlist_Allowed(t *testing.T) {
+	allowlist, err := ParseSenderAllowlist(strings.NewReader(`
+# family
+Jorge@Larix.cl
+example.com
+*.example.org
+`))
+	require.Nil(t, err)
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{address: "jorge@larix.cl", want: true},
+		{address: "Jorge Riquelme <JORGE@larix.cl>", want: true},
+		{address: "someone@larix.cl", want: false},
+		{address: "someone@example.com", want: true},
+		{address: "someone@mail.example.com", want: false},
+		{address: "someone@mail.example.org", want: true},
+		{address: "someone@example.org", want: false},
+		{address: "someone@evilexample.org", want: false},
+		{address: "not an address", want: false},
+		{address: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.Equalf(t, tt.want, allowlist.Allowed(tt.address), "Allowed(%v)", tt.address)
+		})
+	}
+}
+
+func TestLoadConfiguredSenderAllowlist(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	require.Nil(t, storage.Upload(ctx, "config", "allowlist.txt", "text/plain",
+		strings.NewReader("# family\nlarix.cl\n")))
+
+	// nobody configured, everybody is allowed
+	allowlist, err := LoadConfiguredSenderAllowlist(ctx, storage, "", "")
+	require.Nil(t, err)
+	assert.Nil(t, allowlist)
+	// the entries are preferred over the object
+	allowlist, err = LoadConfiguredSenderAllowlist(ctx, storage, "jorge@larix.cl, *.larix.cl", "config/allowlist.txt")
+	require.Nil(t, err)
+	assert.True(t, allowlist.Allowed("jorge@larix.cl"))
+	assert.False(t, allowlist.Allowed("otro@larix.cl"))
+	allowlist, err = LoadConfiguredSenderAllowlist(ctx, storage, "", "config/allowlist.txt")
+	require.Nil(t, err)
+	assert.True(t, allowlist.Allowed("otro@larix.cl"))
+	// an object without bucket
+	_, err = LoadConfiguredSenderAllowlist(ctx, storage, "", "allowlist.txt")
+	assert.NotNil(t, err)
+}
+
+func TestQRApp_HandlerUnauthorizedSender(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification
+	msg, err := testingMsg("snsemail-no-attachment.json")
+	require.Nil(t, err)
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+	expectedEmailBucket := msg.Receipt.Action.BucketName
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedSubject := msg.Mail.CommonHeaders.Subject
+	expectedEmailAddr := msg.Receipt.Recipients[0]
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, expectedEmailBucket, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// mock email reply
+	mailer := &MockMailer{}
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, expectedEmailAddr, expectedReturnPath, expectedSubject,
+		"lo siento, no estás autorizado para publicar archivos.",
+		"<p>lo siento, no estás autorizado para publicar archivos.</p>").Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:             storage,
+		Mailer:              mailer,
+		FilesBucket:         "qr.mydomain.com",
+		FilesBucketURL:      "http://qr.mydomain.com",
+		Allowlist:           NewSenderAllowlist([]string{"mydomain.com"}),
+		ReplyToUnauthorized: true,
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestLoadSenderAllowlist(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	err := storage.Upload(ctx, "config", "allowlist.txt", "text/plain", strings.NewReader("larix.cl\n"))
+	require.Nil(t, err)
+	allowlist, err := LoadSenderAllowlist(ctx, storage, "config", "allowlist.txt")
+	require.Nil(t, err)
+	assert.True(t, allowlist.Allowed("jorge@larix.cl"))
+	_, err = LoadSenderAllowlist(ctx, storage, "config", "missing.txt")
+	assert.NotNil(t, err)
+}
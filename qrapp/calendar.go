@@ -0,0 +1,289 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltpl "html/template"
+	"io"
+	"log"
+	"strings"
+	"time"
+	// embedded time zones, the Lambda runtime doesn't include them
+	_ "time/tzdata"
+
+	"github.com/jhillyerd/enmime"
+)
+
+const (
+	// eventModePage publishes the .ics file and a page with the event, pointed by the QR code.
+	eventModePage = "page"
+	// eventModeQR encodes the event in the QR code, without publishing anything else.
+	eventModeQR = "qr"
+	// maxEventLength is the maximum length (in bytes) of the events encoded in the QR codes. Longer events are
+	// published in a page.
+	maxEventLength = 1024
+)
+
+// eventProperties are the properties of the events encoded in the QR codes, the ones read by the phone cameras.
+var eventProperties = []string{"SUMMARY", "DTSTART", "DTEND", "LOCATION", "DESCRIPTION", "URL"}
+
+// icalProperty is a property (content line) of an iCalendar component: NAME;PARAM=value:value
+type icalProperty struct {
+	params map[string]string
+	value  string
+	line   string
+}
+
+// calendarEvent is the first VEVENT component of an iCalendar file.
+type calendarEvent struct {
+	properties map[string]icalProperty
+}
+
+// parseEvent returns the first event of the iCalendar file.
+func parseEvent(ics []byte) (*calendarEvent, error) {
+	// unfold the lines: long lines are split in lines starting with a space or tab
+	text := strings.ReplaceAll(string(ics), "\r\n", "\n")
+	text = strings.NewReplacer("\n ", "", "\n\t", "").Replace(text)
+	var event *calendarEvent
+	// depth of the components nested in the event (e.g. VALARM), whose properties are ignored
+	depth := 0
+	for _, line := range strings.Split(text, "\n") {
+		nameAndParams, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, params, _ := strings.Cut(nameAndParams, ";")
+		name = strings.ToUpper(name)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = &calendarEvent{properties: make(map[string]icalProperty)}
+		case name == "BEGIN" && event != nil:
+			depth++
+		case name == "END" && depth > 0:
+			depth--
+		case name == "END" && strings.EqualFold(value, "VEVENT") && event != nil:
+			if event.summary() == "" || event.value("DTSTART") == "" {
+				return nil, fmt.Errorf("event without SUMMARY or DTSTART")
+			}
+			return event, nil
+		case event != nil && depth == 0:
+			if _, exists := event.properties[name]; !exists {
+				event.properties[name] = icalProperty{params: parseICalParams(params), value: value, line: line}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no VEVENT found")
+}
+
+// parseICalParams parses the parameters of a property: TZID=America/Santiago;VALUE=DATE-TIME
+func parseICalParams(s string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(param, "=")
+		if ok {
+			params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
+
+var icalUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+// value returns the unescaped text value of the property.
+func (e *calendarEvent) value(name string) string {
+	return icalUnescaper.Replace(e.properties[name].value)
+}
+
+func (e *calendarEvent) summary() string {
+	return strings.TrimSpace(e.value("SUMMARY"))
+}
+
+// vevent returns the event with just the properties read by the phone cameras, to encode it in a QR code.
+func (e *calendarEvent) vevent() string {
+	lines := []string{"BEGIN:VEVENT"}
+	for _, name := range eventProperties {
+		if p, ok := e.properties[name]; ok {
+			lines = append(lines, p.line)
+		}
+	}
+	lines = append(lines, "END:VEVENT")
+	return strings.Join(lines, "\r\n")
+}
+
+// eventTime is a DTSTART or DTEND of an event.
+type eventTime struct {
+	time.Time
+	// allDay is true for dates without time.
+	allDay bool
+}
+
+// time returns the DTSTART or DTEND of the event. Times in UTC are shown in the local time zone (TZ).
+func (e *calendarEvent) time(name string) (eventTime, bool) {
+	p, ok := e.properties[name]
+	if !ok {
+		return eventTime{}, false
+	}
+	value := strings.TrimSpace(p.value)
+	if t, err := time.Parse("20060102", value); err == nil {
+		return eventTime{Time: t, allDay: true}, true
+	}
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return eventTime{Time: t.In(time.Local)}, true
+	}
+	loc := time.Local
+	if tzid, ok := p.params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return eventTime{Time: t}, true
+	}
+	return eventTime{}, false
+}
+
+var (
+	spanishWeekdays = []string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"}
+	spanishMonths   = []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre",
+		"octubre", "noviembre", "diciembre"}
+)
+
+// spanishDate formats the date of t: sábado 10 de mayo de 2025
+func spanishDate(t time.Time) string {
+	return fmt.Sprintf("%s %d de %s de %d", spanishWeekdays[t.Weekday()], t.Day(), spanishMonths[t.Month()-1], t.Year())
+}
+
+// when describes when the event takes place, in Spanish: sábado 10 de mayo de 2025, de 19:00 a 22:00
+func (e *calendarEvent) when() string {
+	start, ok := e.time("DTSTART")
+	if !ok {
+		return e.value("DTSTART")
+	}
+	end, hasEnd := e.time("DTEND")
+	if start.allDay {
+		// the end date of all day events is exclusive
+		if hasEnd && end.AddDate(0, 0, -1).After(start.Time) {
+			return fmt.Sprintf("del %s al %s", spanishDate(start.Time), spanishDate(end.AddDate(0, 0, -1)))
+		}
+		return spanishDate(start.Time)
+	}
+	zone := start.Format("MST")
+	switch {
+	case !hasEnd || end.allDay:
+		return fmt.Sprintf("%s, a las %s (%s)", spanishDate(start.Time), start.Format("15:04"), zone)
+	case end.Year() == start.Year() && end.YearDay() == start.YearDay():
+		return fmt.Sprintf("%s, de %s a %s (%s)", spanishDate(start.Time), start.Format("15:04"), end.Format("15:04"),
+			zone)
+	default:
+		return fmt.Sprintf("desde el %s a las %s hasta el %s a las %s (%s)", spanishDate(start.Time),
+			start.Format("15:04"), spanishDate(end.Time), end.Format("15:04"), zone)
+	}
+}
+
+var eventPageTpl = htmltpl.Must(htmltpl.New("eventPage").Parse(`<!DOCTYPE html>
+<html lang="es">
+    <head>
+        <meta charset="utf-8"/>
+        <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+        <title>{{.Summary}}</title>
+        <style>
+            body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+            .description { white-space: pre-line; }
+            .add { display: inline-block; padding: 0.8em 1.2em; background: #003366; color: #fff; text-decoration: none; }
+        </style>
+    </head>
+    <body>
+        <h1>{{.Summary}}</h1>
+        <p><b>Cuándo:</b> {{.When}}</p>
+{{- with .Location}}
+        <p><b>Dónde:</b> {{.}}</p>
+{{- end}}
+{{- with .Description}}
+        <p class="description">{{.}}</p>
+{{- end}}
+{{- with .URL}}
+        <p><a href="{{.}}">{{.}}</a></p>
+{{- end}}
+        <p><a class="add" href="{{.ICSURL}}">Agregar a mi calendario</a></p>
+    </body>
+</html>
+`))
+
+// eventPageData is the data used to evaluate the event page template.
+type eventPageData struct {
+	Summary     string
+	When        string
+	Location    string
+	Description string
+	URL         string
+	ICSURL      string
+}
+
+// writeEventPage writes the page of the event, with a link to add it to the calendar (the .ics file in icsURL).
+func writeEventPage(w io.Writer, event *calendarEvent, icsURL string) error {
+	return eventPageTpl.Execute(w, &eventPageData{
+		Summary:     event.summary(),
+		When:        event.when(),
+		Location:    event.value("LOCATION"),
+		Description: event.value("DESCRIPTION"),
+		URL:         event.value("URL"),
+		ICSURL:      icsURL,
+	})
+}
+
+// processEvent generates the QR code of the event of an iCalendar file: encoding the event in the QR code (event=qr) or
+// publishing the file and a page with the event, pointed by the QR code. Events too large for a QR code are published
+// in a page, and files without events like any other file.
+func (q *QRApp) processEvent(ctx context.Context, req *request, attachment *enmime.Part, bkgImg string) (ProcessingResult, error) {
+	event, err := parseEvent(attachment.Content)
+	if err != nil {
+		log.Printf("couldn't parse the event of %s, publishing it: %s", attachment.FileName, err)
+		return q.publishAttachment(ctx, req, attachment, bkgImg)
+	}
+	if req.options.Event == eventModeQR {
+		content := event.vevent()
+		if fitsQR(content, maxEventLength, req.options.QR) {
+			return q.publishContentQR(ctx, req, attachment, content, bkgImg)
+		}
+		log.Printf("event %s too large for a QR code (%d bytes), publishing a page", attachment.FileName, len(content))
+	}
+	return q.publishEventPage(ctx, req, attachment, event, bkgImg)
+}
+
+// publishEventPage publishes the iCalendar file, the page of its event (next to the file, adding .html to its key) and
+// the QR code of the page.
+func (q *QRApp) publishEventPage(ctx context.Context, req *request, attachment *enmime.Part, event *calendarEvent, bkgImg string) (result ProcessingResult, err error) {
+	icsKey, err := req.options.KeyStrategy.attachmentKey(req.meta, attachment)
+	if err != nil {
+		return
+	}
+	pageKey := icsKey + ".html"
+	qrImgKey, err := req.options.KeyStrategy.qrImageKey(icsKey, attachment.FileName, bkgImg, req.options.QR)
+	if err != nil {
+		return
+	}
+	result.AttachmentURL, err = q.filesStaticWebsiteURL(pageKey)
+	if err != nil {
+		return
+	}
+	icsURL, err := q.filesStaticWebsiteURL(icsKey)
+	if err != nil {
+		return
+	}
+	page := &bytes.Buffer{}
+	err = writeEventPage(page, event, icsURL)
+	if err != nil {
+		return
+	}
+	objects := []publishedObject{
+		{key: icsKey, contentType: attachment.ContentType, content: attachment.Content},
+		{key: pageKey, contentType: "text/html; charset=utf-8", content: page.Bytes()},
+	}
+	err = q.publishWithQR(ctx, req, result.AttachmentURL, attachment.FileName, qrImgKey, bkgImg, objects, &result)
+	if err != nil {
+		return
+	}
+	result.Size = int64(len(attachment.Content))
+	return
+}
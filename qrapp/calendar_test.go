@@ -0,0 +1,170 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testICS = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Larix//QR//ES\r\nBEGIN:VEVENT\r\nUID:cumple@larix.cl\r\n" +
+	"DTSTAMP:20250401T120000Z\r\nSUMMARY:Cumpleaños de la abuela\r\n" +
+	"DTSTART;TZID=America/Santiago:20250510T190000\r\nDTEND;TZID=America/Santiago:20250510T220000\r\n" +
+	"LOCATION:Av. Siempre Viva 742\\, Santiago\r\nDESCRIPTION:Traer torta.\\nNo llegar tarde\r\n" +
+	"  (es sorpresa).\r\nBEGIN:VALARM\r\nACTION:DISPLAY\r\nDESCRIPTION:Recordatorio\r\nEND:VALARM\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func Test_parseEvent(t *testing.T) {
+	event, err := parseEvent([]byte(testICS))
+	require.Nil(t, err)
+	assert.Equal(t, "Cumpleaños de la abuela", event.summary())
+	assert.Equal(t, "Av. Siempre Viva 742, Santiago", event.value("LOCATION"))
+	assert.Equal(t, "Traer torta.\nNo llegar tarde (es sorpresa).", event.value("DESCRIPTION"))
+	assert.Equal(t, "sábado 10 de mayo de 2025, de 19:00 a 22:00 (-04)", event.when())
+	expectedVEvent := "BEGIN:VEVENT\r\nSUMMARY:Cumpleaños de la abuela\r\nDTSTART;TZID=America/Santiago:20250510T190000\r\n" +
+		"DTEND;TZID=America/Santiago:20250510T220000\r\nLOCATION:Av. Siempre Viva 742\\, Santiago\r\n" +
+		"DESCRIPTION:Traer torta.\\nNo llegar tarde (es sorpresa).\r\nEND:VEVENT"
+	assert.Equal(t, expectedVEvent, event.vevent())
+
+	_, err = parseEvent([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	assert.NotNil(t, err)
+	_, err = parseEvent([]byte("BEGIN:VEVENT\r\nSUMMARY:sin fecha\r\nEND:VEVENT\r\n"))
+	assert.NotNil(t, err)
+}
+
+func Test_calendarEvent_when(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		want  string
+	}{
+		{
+			name:  "all day",
+			start: "DTSTART;VALUE=DATE:20250918",
+			end:   "DTEND;VALUE=DATE:20250919",
+			want:  "jueves 18 de septiembre de 2025",
+		},
+		{
+			name:  "several days",
+			start: "DTSTART;VALUE=DATE:20250918",
+			end:   "DTEND;VALUE=DATE:20250921",
+			want:  "del jueves 18 de septiembre de 2025 al sábado 20 de septiembre de 2025",
+		},
+		{
+			name:  "without end",
+			start: "DTSTART;TZID=Europe/Madrid:20251224T210000",
+			want:  "miércoles 24 de diciembre de 2025, a las 21:00 (CET)",
+		},
+		{
+			name:  "overnight",
+			start: "DTSTART;TZID=Europe/Madrid:20251231T220000",
+			end:   "DTEND;TZID=Europe/Madrid:20260101T030000",
+			want:  "desde el miércoles 31 de diciembre de 2025 a las 22:00 hasta el jueves 1 de enero de 2026 a las 03:00 (CET)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ics := "BEGIN:VEVENT\r\nSUMMARY:evento\r\n" + tt.start + "\r\n"
+			if tt.end != "" {
+				ics += tt.end + "\r\n"
+			}
+			event, err := parseEvent([]byte(ics + "END:VEVENT\r\n"))
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, event.when())
+		})
+	}
+}
+
+func TestQRApp_ProcessRawEmailWithEvent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		subject string
+		// mock sets the expected uploads, returning the expected reply
+		mock func(storage *MockStorage) string
+	}{
+		{
+			name:    "page",
+			subject: "cumpleaños",
+			mock: func(storage *MockStorage) string {
+				storage.On("Upload", ctxMatcher, "qr.mydomain.com", "cumple.ics", "text/calendar", mock.Anything).Return(nil)
+				withEvent := func(r io.Reader) bool {
+					page, err := io.ReadAll(r)
+					return err == nil && strings.Contains(string(page), "<h1>Cumpleaños de la abuela</h1>") &&
+						strings.Contains(string(page), `href="http://qr.mydomain.com/cumple.ics"`)
+				}
+				storage.On("Upload", ctxMatcher, "qr.mydomain.com", "cumple.ics.html", "text/html; charset=utf-8",
+					mock.MatchedBy(withEvent)).Return(nil)
+				withPageURL := func(f *os.File) bool {
+					return verifyQR(f.Name(), "http://qr.mydomain.com/cumple.ics.html") == nil
+				}
+				storage.On("Upload", ctxMatcher, "qr.mydomain.com", "cumple.ics.qr.png", "image/png",
+					mock.MatchedBy(withPageURL)).Return(nil)
+				return "cumple.ics quedó en http://qr.mydomain.com/cumple.ics.html. El QR está en http://qr.mydomain.com/cumple.ics.qr.png."
+			},
+		},
+		{
+			name:    "qr",
+			subject: "cumpleaños event=qr",
+			mock: func(storage *MockStorage) string {
+				event, _ := parseEvent([]byte(testICS))
+				withEvent := func(f *os.File) bool {
+					return verifyQR(f.Name(), event.vevent()) == nil
+				}
+				storage.On("Upload", ctxMatcher, "qr.mydomain.com", "cumple.ics.qr.png", "image/png",
+					mock.MatchedBy(withEvent)).Return(nil)
+				return "cumple.ics va dentro del QR, sin publicar el archivo. El QR está en http://qr.mydomain.com/cumple.ics.qr.png."
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			part, err := enmime.Builder().
+				From("Jorge", "jorge@larix.cl").
+				To("QR", "qr@mydomain.com").
+				Subject(tt.subject).
+				Header("Message-Id", "<cumple@larix.cl>").
+				Text([]byte("el cumpleaños")).
+				AddAttachment([]byte(testICS), "text/calendar", "cumple.ics").
+				Build()
+			require.Nil(t, err)
+			email := &bytes.Buffer{}
+			require.Nil(t, part.Encode(email))
+
+			storage := &MockStorage{}
+			expectedTxt := tt.mock(storage)
+			mailer := &MockMailer{}
+			mailer.On("SendReply", ctxMatcher, "<cumple@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", tt.subject,
+				expectedTxt, mock.Anything).Return(nil)
+
+			// SUT
+			q := &QRApp{
+				Storage:        storage,
+				Mailer:         mailer,
+				FilesBucket:    "qr.mydomain.com",
+				FilesBucketURL: "http://qr.mydomain.com",
+			}
+			// test
+			err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+				ReplyFrom:  "qr@mydomain.com",
+				ReturnPath: "jorge@larix.cl",
+			})
+			assert.Nil(t, err)
+
+			// check mocks
+			mock.AssertExpectationsForObjects(t, storage, mailer)
+		})
+	}
+}
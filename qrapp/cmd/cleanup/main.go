@@ -0,0 +1,48 @@
+// Command cleanup is a Lambda, run on a schedule, deleting the expired files from FILES_BUCKET (see the expires option).
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jriquelme/home-it-services/qrapp"
+)
+
+func main() {
+	// get env variables
+	filesBucket := os.Getenv("FILES_BUCKET")
+	if filesBucket == "" {
+		log.Fatalf("missing FILES_BUCKET")
+	}
+	recordsBucket := os.Getenv("RECORDS_BUCKET")
+	if recordsBucket == "" {
+		log.Fatalf("missing RECORDS_BUCKET")
+	}
+	// load aws config
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	// run lambda
+	s3Cli := s3.NewFromConfig(cfg)
+	app := &qrapp.QRApp{
+		Storage: &qrapp.S3Storage{
+			S3Downloader: manager.NewDownloader(s3Cli),
+			S3Uploader:   manager.NewUploader(s3Cli),
+			S3Client:     s3Cli,
+		},
+		FilesBucket:   filesBucket,
+		RecordsBucket: recordsBucket,
+	}
+	lambda.Start(func(ctx context.Context) error {
+		deleted, err := app.DeleteExpired(ctx, time.Now())
+		log.Printf("deleted %d expired publications", deleted)
+		return err
+	})
+}
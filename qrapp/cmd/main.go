@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -41,6 +43,44 @@ func main() {
 		Mailer:         mailer,
 		FilesBucket:    filesBucket,
 		FilesBucketURL: "http://" + filesBucket,
+		VerdictPolicy: &qrapp.VerdictPolicy{
+			RejectSpam:       true,
+			RejectVirus:      true,
+			RequireSPFOrDKIM: true,
+			ReplyOnReject:    os.Getenv("REPLY_ON_REJECT") == "true",
+		},
+		ReplyToUnauthorized: os.Getenv("REPLY_TO_UNAUTHORIZED") == "true",
+	}
+	if name := os.Getenv("KEY_STRATEGY"); name != "" {
+		app.KeyStrategy, err = qrapp.ParseKeyStrategy(name)
+		if err != nil {
+			log.Fatalf("invalid KEY_STRATEGY, %v", err)
+		}
+	}
+	if modes := os.Getenv("QR_MODES"); modes != "" {
+		app.Modes, err = qrapp.ParseModes(modes)
+		if err != nil {
+			log.Fatalf("invalid QR_MODES, %v", err)
+		}
+	}
+	if recipients := os.Getenv("QR_RECIPIENTS"); recipients != "" {
+		app.Recipients = strings.Split(recipients, ",")
+	}
+	app.RecordsBucket = os.Getenv("RECORDS_BUCKET")
+	if expires := os.Getenv("QR_EXPIRES"); expires != "" {
+		app.Expiration, err = qrapp.ParseExpiration(expires)
+		if err != nil {
+			log.Fatalf("invalid QR_EXPIRES, %v", err)
+		}
+	}
+	app.QROptions.Logo, err = loadLogo(context.TODO(), storage)
+	if err != nil {
+		log.Fatalf("unable to load logo, %v", err)
+	}
+	app.Allowlist, err = qrapp.LoadConfiguredSenderAllowlist(context.TODO(), storage, os.Getenv("ALLOWED_SENDERS"),
+		os.Getenv("ALLOWED_SENDERS_OBJECT"))
+	if err != nil {
+		log.Fatalf("unable to load sender allowlist, %v", err)
 	}
 	lambda.Start(func(ctx context.Context, event events.SNSEvent) error {
 		for _, record := range event.Records {
@@ -59,3 +99,19 @@ func main() {
 		return nil
 	})
 }
+
+// loadLogo returns the path of the logo placed in the center of the QR codes: QR_LOGO or the object QR_LOGO_OBJECT
+// (bucket/key) downloaded to a temporary file. Without any of them, the QR codes don't have a logo.
+func loadLogo(ctx context.Context, storage qrapp.Storage) (string, error) {
+	if logo := os.Getenv("QR_LOGO"); logo != "" {
+		return logo, nil
+	}
+	if object := os.Getenv("QR_LOGO_OBJECT"); object != "" {
+		bucket, key, ok := strings.Cut(object, "/")
+		if !ok {
+			return "", fmt.Errorf("invalid QR_LOGO_OBJECT %q (bucket/key)", object)
+		}
+		return qrapp.LoadLogo(ctx, storage, bucket, key)
+	}
+	return "", nil
+}
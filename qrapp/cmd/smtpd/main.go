@@ -0,0 +1,143 @@
+// Command smtpd runs QRApp as an SMTP server, so it can be self-hosted receiving emails directly (e.g. pointing the MX
+// record of a domain to it), storing the files in a local directory and replying through an SMTP relay.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/jriquelme/home-it-services/qrapp"
+)
+
+func main() {
+	// get env variables
+	recipients := strings.Split(os.Getenv("QR_RECIPIENTS"), ",")
+	if recipients[0] == "" {
+		log.Fatalf("missing QR_RECIPIENTS")
+	}
+	filesDir := os.Getenv("FILES_DIR")
+	if filesDir == "" {
+		log.Fatalf("missing FILES_DIR")
+	}
+	filesBucket := os.Getenv("FILES_BUCKET")
+	if filesBucket == "" {
+		log.Fatalf("missing FILES_BUCKET")
+	}
+	filesBucketURL := os.Getenv("FILES_BUCKET_URL")
+	if filesBucketURL == "" {
+		filesBucketURL = "http://" + filesBucket
+	}
+	relayAddr := os.Getenv("SMTP_RELAY_ADDR")
+	if relayAddr == "" {
+		log.Fatalf("missing SMTP_RELAY_ADDR")
+	}
+	security, ok := map[string]qrapp.SMTPSecurity{
+		"":         qrapp.SMTPSecuritySTARTTLS,
+		"none":     qrapp.SMTPSecurityNone,
+		"starttls": qrapp.SMTPSecuritySTARTTLS,
+		"tls":      qrapp.SMTPSecurityTLS,
+	}[os.Getenv("SMTP_RELAY_SECURITY")]
+	if !ok {
+		log.Fatalf("invalid SMTP_RELAY_SECURITY (none, starttls or tls)")
+	}
+	auth, ok := map[string]qrapp.SMTPAuth{
+		"":      qrapp.SMTPAuthNone,
+		"none":  qrapp.SMTPAuthNone,
+		"plain": qrapp.SMTPAuthPlain,
+		"login": qrapp.SMTPAuthLogin,
+	}[os.Getenv("SMTP_RELAY_AUTH")]
+	if !ok {
+		log.Fatalf("invalid SMTP_RELAY_AUTH (none, plain or login)")
+	}
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		addr = ":25"
+	}
+	// configure app
+	app := &qrapp.QRApp{
+		Storage: &qrapp.LocalStorage{
+			Dir: filesDir,
+		},
+		Mailer: &qrapp.SMTPMailer{
+			Addr:     relayAddr,
+			Security: security,
+			Auth:     auth,
+			Username: os.Getenv("SMTP_RELAY_USERNAME"),
+			Password: os.Getenv("SMTP_RELAY_PASSWORD"),
+		},
+		FilesBucket:         filesBucket,
+		FilesBucketURL:      filesBucketURL,
+		ReplyToUnauthorized: os.Getenv("REPLY_TO_UNAUTHORIZED") == "true",
+	}
+	allowlist, err := qrapp.LoadConfiguredSenderAllowlist(context.Background(), app.Storage,
+		os.Getenv("ALLOWED_SENDERS"), os.Getenv("ALLOWED_SENDERS_OBJECT"))
+	if err != nil {
+		log.Fatalf("unable to load sender allowlist, %v", err)
+	}
+	app.Allowlist = allowlist
+	// the senders aren't authenticated (no SPF nor DMARC checks), so anybody could act on the files of others
+	log.Printf("senders aren't authenticated, the delete and list commands are disabled")
+	if name := os.Getenv("KEY_STRATEGY"); name != "" {
+		app.KeyStrategy, err = qrapp.ParseKeyStrategy(name)
+		if err != nil {
+			log.Fatalf("invalid KEY_STRATEGY, %v", err)
+		}
+	}
+	if modes := os.Getenv("QR_MODES"); modes != "" {
+		app.Modes, err = qrapp.ParseModes(modes)
+		if err != nil {
+			log.Fatalf("invalid QR_MODES, %v", err)
+		}
+	}
+	app.QROptions.Logo = os.Getenv("QR_LOGO")
+	// the records are kept next to the files bucket, but not inside it (so they aren't served)
+	app.RecordsBucket = os.Getenv("RECORDS_BUCKET")
+	if app.RecordsBucket == "" {
+		app.RecordsBucket = "qrapp-records"
+	}
+	if expires := os.Getenv("QR_EXPIRES"); expires != "" {
+		app.Expiration, err = qrapp.ParseExpiration(expires)
+		if err != nil {
+			log.Fatalf("invalid QR_EXPIRES, %v", err)
+		}
+	}
+	go deleteExpired(app)
+	// run smtp server
+	server := smtp.NewServer(&qrapp.SMTPBackend{
+		App:            app,
+		Recipients:     recipients,
+		ProcessTimeout: 5 * time.Minute,
+	})
+	server.Addr = addr
+	server.Domain = os.Getenv("SMTP_DOMAIN")
+	if server.Domain == "" {
+		server.Domain = "localhost"
+	}
+	server.AuthDisabled = true
+	server.MaxMessageBytes = 25 * 1024 * 1024
+	server.MaxRecipients = 10
+	server.ReadTimeout = time.Minute
+	server.WriteTimeout = time.Minute
+	log.Printf("listening on %s for %s", addr, strings.Join(recipients, ", "))
+	err = server.ListenAndServe()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// deleteExpired deletes the expired files every hour.
+func deleteExpired(app *qrapp.QRApp) {
+	for ; ; time.Sleep(time.Hour) {
+		deleted, err := app.DeleteExpired(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("couldn't delete expired files: %s", err)
+		}
+		if deleted > 0 {
+			log.Printf("deleted %d expired publications", deleted)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package qrapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+)
+
+const (
+	// commandDelete deletes what was published for an email, replying "borrar" (or "delete") to the reply of QRApp.
+	commandDelete = "delete"
+	// commandList lists the files published by the sender, sending an email with subject "list".
+	commandList = "list"
+	// commandHelp describes the modes, options and commands, sending an email with subject "help".
+	commandHelp = "help"
+)
+
+// command is a command accepted by parseCommand.
+type command struct {
+	name string
+	// words are the words triggering the command, in the subject or as a reply.
+	words []string
+	// reply is true for the commands given replying to a reply of QRApp (in the body), false for the ones given in
+	// the subject.
+	reply bool
+	// authenticated is true for the commands acting on the published files of the sender, so they require an
+	// authenticated sender (see EmailMetadata.AuthenticatedSenders).
+	authenticated bool
+	help          string
+}
+
+// commands is the registry of all the commands accepted by parseCommand.
+var commands = []*command{
+	{
+		name:          commandDelete,
+		words:         []string{"borrar", "delete"},
+		reply:         true,
+		authenticated: true,
+		help:          "responde con esta palabra a mi respuesta para borrar todo lo publicado para ese correo",
+	},
+	{
+		name:          commandList,
+		words:         []string{"lista", "list"},
+		authenticated: true,
+		help:          "escríbela como asunto para recibir la lista de todo lo que tienes publicado",
+	},
+	{
+		name:  commandHelp,
+		words: []string{"ayuda", "help"},
+		help:  "escríbela como asunto para recibir estas instrucciones",
+	},
+}
+
+// parseCommand returns the command of the email, or "" if it isn't a command (to publish its attachments).
+func parseCommand(envelope *enmime.Envelope) string {
+	subject := strings.ToLower(strings.TrimSpace(envelope.GetHeader("Subject")))
+	isReply := len(referencedMessageIDs(envelope)) > 0
+	var word string
+	if isReply {
+		word = firstWord(envelope.Text)
+	}
+	for _, cmd := range commands {
+		for _, w := range cmd.words {
+			if (cmd.reply && isReply && w == word) || (!cmd.reply && w == subject) {
+				return cmd.name
+			}
+		}
+	}
+	return ""
+}
+
+// commandNamed returns the command with the given name, or nil if there's none.
+func commandNamed(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// firstWord returns the first line of text, lowercased and without punctuation, if it's a single word.
+func firstWord(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" {
+			continue
+		}
+		word := strings.TrimRight(line, ".!¡")
+		if strings.ContainsAny(word, " \t") {
+			return ""
+		}
+		return word
+	}
+	return ""
+}
+
+var messageIDRegexp = regexp.MustCompile(`<[^<>\s]+>`)
+
+// referencedMessageIDs returns the Message-IDs of the emails the email replies to, the most recent first: the
+// In-Reply-To and then the References (which lists the thread from the oldest email).
+func referencedMessageIDs(envelope *enmime.Envelope) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range messageIDRegexp.FindAllString(envelope.GetHeader("In-Reply-To"), -1) {
+		add(id)
+	}
+	references := messageIDRegexp.FindAllString(envelope.GetHeader("References"), -1)
+	for i := len(references) - 1; i >= 0; i-- {
+		add(references[i])
+	}
+	return ids
+}
+
+// DeleteResult is the result of the delete command.
+type DeleteResult struct {
+	// URLs of the deleted files.
+	URLs []string
+	// Kept are the URLs of the files not deleted because other emails also published them.
+	Kept  []string
+	Error error
+}
+
+// processDelete deletes the files published for the most recent email of the thread the email replies to (only the
+// ones published by the same authenticated sender), replying with the deleted files.
+func (q *QRApp) processDelete(ctx context.Context, req *request, envelope *enmime.Envelope) error {
+	result := &DeleteResult{}
+	publication, err := q.findPublication(ctx, req.meta.authenticatedSenders(), referencedMessageIDs(envelope))
+	switch {
+	case err != nil:
+		result.Error = err
+	case publication != nil:
+		var kept []string
+		kept, result.Error = q.deletePublication(ctx, publication)
+		keptKeys := make(map[string]bool)
+		for _, key := range kept {
+			keptKeys[key] = true
+		}
+		for _, key := range publication.Keys {
+			u, err := q.filesStaticWebsiteURL(key)
+			if err != nil {
+				u = key
+			}
+			if keptKeys[key] {
+				result.Kept = append(result.Kept, u)
+			} else {
+				result.URLs = append(result.URLs, u)
+			}
+		}
+	}
+	if result.Error != nil {
+		log.Printf("couldn't delete the files of %s: %s", req.meta.MessageID, result.Error)
+	}
+	return q.reply(ctx, req, &replyData{Deleted: result}, nil)
+}
+
+// findPublication returns the record of the first email of messageIDs with files published by any of the senders, or
+// nil if none.
+func (q *QRApp) findPublication(ctx context.Context, senders []string, messageIDs []string) (*Publication, error) {
+	if q.RecordsBucket == "" {
+		return nil, fmt.Errorf("no anoto lo que publico, así que no sé qué borrar")
+	}
+	for _, id := range messageIDs {
+		for _, sender := range senders {
+			key := publicationKey(sender, id)
+			exists, err := q.Storage.Exists(ctx, q.RecordsBucket, key)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't check if %s exists: %s", key, err)
+			}
+			if exists {
+				return q.loadPublication(ctx, key)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ListResult is the result of the list command.
+type ListResult struct {
+	Files []ListedFile
+	Error error
+}
+
+// ListedFile is a published file, formatted to be listed in the reply.
+type ListedFile struct {
+	Name       string
+	URL        string
+	QRImageURL string
+	Published  string
+	// Expires is when the file will be deleted (empty if never).
+	Expires string
+	// Size is the formatted size of the file (empty for URLs).
+	Size string
+}
+
+// processList replies with the files published by the authenticated sender, the most recent first.
+func (q *QRApp) processList(ctx context.Context, req *request) error {
+	result := &ListResult{}
+	result.Files, result.Error = q.listPublished(ctx, req.meta.authenticatedSenders())
+	if result.Error != nil {
+		log.Printf("couldn't list the files of %s: %s", req.meta.ReturnPath, result.Error)
+	}
+	return q.reply(ctx, req, &replyData{Listed: result}, nil)
+}
+
+// listPublished returns the files published by any of the senders, according to the records in RecordsBucket.
+func (q *QRApp) listPublished(ctx context.Context, senders []string) ([]ListedFile, error) {
+	if q.RecordsBucket == "" {
+		return nil, fmt.Errorf("no anoto lo que publico, así que no sé qué tienes publicado")
+	}
+	var keys []string
+	for _, sender := range senders {
+		senderKeys, err := q.Storage.List(ctx, q.RecordsBucket, senderPublicationsPrefix(sender))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list the publications: %s", err)
+		}
+		keys = append(keys, senderKeys...)
+	}
+	publications := make([]*Publication, 0, len(keys))
+	for _, key := range keys {
+		publication, err := q.loadPublication(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		publications = append(publications, publication)
+	}
+	sort.SliceStable(publications, func(i, j int) bool {
+		return publications[i].Published.After(publications[j].Published)
+	})
+	var files []ListedFile
+	for _, publication := range publications {
+		for _, file := range publication.Files {
+			listed := ListedFile{
+				Name:       file.Name,
+				URL:        file.URL,
+				QRImageURL: file.QRImageURL,
+				Published:  publication.Published.In(time.Local).Format("02/01/2006 15:04"),
+				Size:       formatSize(file.Size),
+			}
+			if publication.Expires != nil {
+				listed.Expires = publication.Expires.In(time.Local).Format("02/01/2006 15:04")
+			}
+			files = append(files, listed)
+		}
+	}
+	return files, nil
+}
+
+// formatSize formats a size in bytes, in Spanish: 1,5 MB
+func formatSize(size int64) string {
+	switch {
+	case size <= 0:
+		return ""
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return strings.Replace(fmt.Sprintf("%.1f KB", float64(size)/1024), ".", ",", 1)
+	default:
+		return strings.Replace(fmt.Sprintf("%.1f MB", float64(size)/(1024*1024)), ".", ",", 1)
+	}
+}
+
+// HelpResult is the result of the help command: the content types, modes, options and commands, from their
+// registries.
+type HelpResult struct {
+	// ContentTypes are the attachments processed instead of just published.
+	ContentTypes []HelpItem
+	// Bodies are the contents processed in the body of the emails without attachments.
+	Bodies   []HelpItem
+	Modes    []HelpItem
+	Options  []HelpItem
+	Commands []HelpItem
+}
+
+// HelpItem describes a mode, option or command in the reply.
+type HelpItem struct {
+	// Usage is how to use it, e.g. size=1-255 or the address of a mode.
+	Usage string
+	Help  string
+}
+
+// processHelp replies with the description of the content types, modes, options and commands.
+func (q *QRApp) processHelp(ctx context.Context, req *request) error {
+	return q.reply(ctx, req, &replyData{Help: q.help(req.meta.ReplyFrom)}, nil)
+}
+
+// help describes the content types, modes (with their addresses based on the address of QRApp, addr), options and
+// commands.
+func (q *QRApp) help(addr string) *HelpResult {
+	result := &HelpResult{}
+	for _, handler := range attachmentHandlers {
+		usage := handler.extension + " (" + strings.Join(handler.contentTypes, ", ") + ")"
+		result.ContentTypes = append(result.ContentTypes, HelpItem{Usage: usage, Help: handler.help})
+	}
+	for _, handler := range bodyHandlers {
+		result.Bodies = append(result.Bodies, HelpItem{Usage: handler.name, Help: handler.help})
+	}
+	modes := q.modes()
+	names := make([]string, 0, len(modes))
+	for name := range modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result.Modes = append(result.Modes, HelpItem{Usage: modeAddress(addr, name), Help: modes[name]})
+	}
+	for _, opt := range options {
+		result.Options = append(result.Options, HelpItem{Usage: opt.name + "=" + opt.values, Help: opt.help})
+	}
+	for _, cmd := range commands {
+		result.Commands = append(result.Commands, HelpItem{Usage: strings.Join(cmd.words, " o "), Help: cmd.help})
+	}
+	return result
+}
@@ -0,0 +1,445 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		headers map[string]string
+		text    string
+		want    string
+	}{
+		{
+			name:    "delete",
+			headers: map[string]string{"In-Reply-To": "<reply@mydomain.com>"},
+			text:    "\r\nBorrar.\r\n\r\nEl sáb, QR escribió:\r\n> menu.pdf quedó en ...\r\n",
+			want:    commandDelete,
+		},
+		{
+			name:    "delete in english",
+			headers: map[string]string{"References": "<menu@larix.cl> <reply@mydomain.com>"},
+			text:    "delete",
+			want:    commandDelete,
+		},
+		{
+			name: "not a reply",
+			text: "borrar",
+		},
+		{
+			name:    "not just the command",
+			headers: map[string]string{"In-Reply-To": "<reply@mydomain.com>"},
+			text:    "borrar el menú de ayer",
+		},
+		{
+			name:    "list",
+			subject: " List ",
+			want:    commandList,
+		},
+		{
+			name:    "list in spanish",
+			subject: "lista",
+			want:    commandList,
+		},
+		{
+			name:    "not just the list command",
+			subject: "list of files",
+		},
+		{
+			name:    "help",
+			subject: "Ayuda",
+			want:    commandHelp,
+		},
+		{
+			name:    "delete only as a reply",
+			subject: "borrar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject := tt.subject
+			if subject == "" {
+				subject = "Re: menú"
+			}
+			builder := enmime.Builder().From("Jorge", "jorge@larix.cl").To("QR", "qr@mydomain.com").Subject(subject).
+				Text([]byte(tt.text))
+			for name, value := range tt.headers {
+				builder = builder.Header(name, value)
+			}
+			part, err := builder.Build()
+			require.Nil(t, err)
+			email := &bytes.Buffer{}
+			require.Nil(t, part.Encode(email))
+			envelope, err := enmime.ReadEnvelope(email)
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, parseCommand(envelope))
+		})
+	}
+}
+
+func Test_referencedMessageIDs(t *testing.T) {
+	part, err := enmime.Builder().From("Jorge", "jorge@larix.cl").To("QR", "qr@mydomain.com").Subject("Re: menú").
+		Header("In-Reply-To", "<reply@mydomain.com>").
+		Header("References", "<first@larix.cl> <menu@larix.cl> <reply@mydomain.com>").
+		Text([]byte("borrar")).
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+	envelope, err := enmime.ReadEnvelope(email)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"<reply@mydomain.com>", "<menu@larix.cl>", "<first@larix.cl>"},
+		referencedMessageIDs(envelope))
+}
+
+func TestQRApp_ProcessRawEmailDelete(t *testing.T) {
+	t.Parallel()
+
+	// files published for <menu@larix.cl>
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	mailer := &MockMailer{}
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		RecordsBucket:  "records",
+		// the allowlist doesn't authenticate the senders
+		Allowlist: NewSenderAllowlist([]string{"larix.cl", "*.larix.cl"}),
+	}
+	publication := &Publication{
+		MessageID: "<menu@larix.cl>",
+		Sender:    "jorge@larix.cl",
+		Keys:      []string{"menu.pdf", "menu.pdf.qr.png"},
+	}
+	for _, key := range publication.Keys {
+		require.Nil(t, storage.Upload(ctx, q.FilesBucket, key, "", strings.NewReader(key)))
+	}
+	require.Nil(t, q.savePublication(ctx, publication))
+
+	// reply "borrar" to the reply of QRApp (threaded with References), from other sender and from the original one
+	deleteEmail := func(from string) *bytes.Buffer {
+		part, err := enmime.Builder().
+			From("", from).
+			To("QR", "qr@mydomain.com").
+			Subject("Re: menú").
+			Header("Message-Id", "<borrar@larix.cl>").
+			Header("In-Reply-To", "<reply@mydomain.com>").
+			Header("References", "<menu@larix.cl> <reply@mydomain.com>").
+			Text([]byte("borrar\r\n\r\n> menu.pdf quedó en http://qr.mydomain.com/menu.pdf.\r\n")).
+			Build()
+		require.Nil(t, err)
+		email := &bytes.Buffer{}
+		require.Nil(t, part.Encode(email))
+		return email
+	}
+	mailer.On("SendReply", ctxMatcher, "<borrar@larix.cl>", "qr@mydomain.com", "otro@larix.cl", "Re: menú",
+		"No encontré nada publicado para ese correo, quizás ya lo borré.", mock.Anything).Return(nil).Once()
+	err := q.ProcessRawEmail(ctx, deleteEmail("otro@larix.cl"), &EmailMetadata{
+		ReplyFrom:            "qr@mydomain.com",
+		ReturnPath:           "otro@larix.cl",
+		AuthenticatedSenders: []string{"otro@larix.cl"},
+	})
+	assert.Nil(t, err)
+	// nor from other sender forging the From address, with only its return path authenticated (SPF)
+	mailer.On("SendReply", ctxMatcher, "<borrar@larix.cl>", "qr@mydomain.com", "otro@larix.cl", "Re: menú",
+		"No encontré nada publicado para ese correo, quizás ya lo borré.", mock.Anything).Return(nil).Once()
+	err = q.ProcessRawEmail(ctx, deleteEmail("jorge@larix.cl"), &EmailMetadata{
+		ReplyFrom:            "qr@mydomain.com",
+		ReturnPath:           "otro@larix.cl",
+		AuthenticatedSenders: []string{"otro@larix.cl"},
+	})
+	assert.Nil(t, err)
+	// nothing is deleted if the sender wasn't authenticated, even if allowed
+	mailer.On("SendReply", ctxMatcher, "<borrar@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "Re: menú",
+		"lo siento, no pude verificar que el correo sea tuyo, así que no hice nada con tus archivos.",
+		mock.Anything).Return(nil).Once()
+	err = q.ProcessRawEmail(ctx, deleteEmail("jorge@larix.cl"), &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+	// the sender is matched by the authenticated From address (DMARC), even with a rewritten return path (VERP)
+	expectedTxt := `Borré todo lo publicado para ese correo:
+* http://qr.mydomain.com/menu.pdf
+* http://qr.mydomain.com/menu.pdf.qr.png
+`
+	mailer.On("SendReply", ctxMatcher, "<borrar@larix.cl>", "qr@mydomain.com", "bounces+jorge=larix.cl@lists.larix.cl",
+		"Re: menú", expectedTxt, mock.Anything).Return(nil).Once()
+	err = q.ProcessRawEmail(ctx, deleteEmail("jorge@larix.cl"), &EmailMetadata{
+		ReplyFrom:            "qr@mydomain.com",
+		ReturnPath:           "bounces+jorge=larix.cl@lists.larix.cl",
+		AuthenticatedSenders: []string{"Jorge <jorge@larix.cl>"},
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, mailer)
+	// the files and the record were deleted
+	files, err := storage.List(ctx, q.FilesBucket, "")
+	require.Nil(t, err)
+	assert.Empty(t, files)
+	records, err := storage.List(ctx, q.RecordsBucket, "")
+	require.Nil(t, err)
+	assert.Empty(t, records)
+}
+
+func Test_formatSize(t *testing.T) {
+	assert.Equal(t, "", formatSize(0))
+	assert.Equal(t, "512 B", formatSize(512))
+	assert.Equal(t, "1,5 KB", formatSize(1536))
+	assert.Equal(t, "2,0 MB", formatSize(2*1024*1024))
+}
+
+func TestQRApp_ProcessRawEmailList(t *testing.T) {
+	t.Parallel()
+
+	// files published by jorge@larix.cl in two emails, and by somebody else
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	mailer := &MockMailer{}
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		RecordsBucket:  "records",
+	}
+	older := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	newer := time.Date(2026, 10, 16, 18, 30, 0, 0, time.UTC)
+	expires := newer.Add(7 * 24 * time.Hour)
+	publications := []*Publication{
+		{
+			MessageID: "<menu@larix.cl>",
+			Sender:    "jorge@larix.cl",
+			Published: older,
+			Keys:      []string{"menu.pdf", "menu.pdf.qr.png"},
+			Files: []PublishedFile{{Name: "menu.pdf", URL: "http://qr.mydomain.com/menu.pdf",
+				QRImageURL: "http://qr.mydomain.com/menu.pdf.qr.png", Size: 1536}},
+		},
+		{
+			MessageID: "<links@larix.cl>",
+			Sender:    "jorge@larix.cl",
+			Published: newer,
+			Expires:   &expires,
+			Keys:      []string{"example-com.qr.png"},
+			Files: []PublishedFile{{Name: "https://example.com", URL: "https://example.com",
+				QRImageURL: "http://qr.mydomain.com/example-com.qr.png"}},
+		},
+		{
+			MessageID: "<otro@larix.cl>",
+			Sender:    "otro@larix.cl",
+			Published: newer,
+			Keys:      []string{"otro.pdf", "otro.pdf.qr.png"},
+			Files: []PublishedFile{{Name: "otro.pdf", URL: "http://qr.mydomain.com/otro.pdf",
+				QRImageURL: "http://qr.mydomain.com/otro.pdf.qr.png", Size: 100}},
+		},
+	}
+	for _, publication := range publications {
+		require.Nil(t, q.savePublication(ctx, publication))
+	}
+
+	// email with subject list, replied with the files of the sender, the most recent first
+	listEmail := func() *bytes.Buffer {
+		part, err := enmime.Builder().
+			From("Jorge", "jorge@larix.cl").
+			To("QR", "qr@mydomain.com").
+			Subject("list").
+			Header("Message-Id", "<list@larix.cl>").
+			Text([]byte("qué tengo publicado?")).
+			Build()
+		require.Nil(t, err)
+		email := &bytes.Buffer{}
+		require.Nil(t, part.Encode(email))
+		return email
+	}
+	format := func(t time.Time) string {
+		return t.In(time.Local).Format("02/01/2006 15:04")
+	}
+	expectedTxt := "Tienes publicado:\n" +
+		"* https://example.com: https://example.com, QR en http://qr.mydomain.com/example-com.qr.png (publicado el " +
+		format(newer) + ", se borra el " + format(expires) + ")\n" +
+		"* menu.pdf: http://qr.mydomain.com/menu.pdf, QR en http://qr.mydomain.com/menu.pdf.qr.png (publicado el " +
+		format(older) + ", 1,5 KB)\n"
+	mailer.On("SendReply", ctxMatcher, "<list@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "list", expectedTxt,
+		mock.Anything).Return(nil)
+	err := q.ProcessRawEmail(ctx, listEmail(), &EmailMetadata{
+		ReplyFrom:            "qr@mydomain.com",
+		ReturnPath:           "jorge@larix.cl",
+		AuthenticatedSenders: []string{"jorge@larix.cl"},
+	})
+	assert.Nil(t, err)
+
+	// other sender forging the From address, with only its return path authenticated (SPF), gets its own files
+	expectedTxt = "Tienes publicado:\n" +
+		"* otro.pdf: http://qr.mydomain.com/otro.pdf, QR en http://qr.mydomain.com/otro.pdf.qr.png (publicado el " +
+		format(newer) + ", 100 B)\n"
+	mailer.On("SendReply", ctxMatcher, "<list@larix.cl>", "qr@mydomain.com", "otro@larix.cl", "list", expectedTxt,
+		mock.Anything).Return(nil)
+	err = q.ProcessRawEmail(ctx, listEmail(), &EmailMetadata{
+		ReplyFrom:            "qr@mydomain.com",
+		ReturnPath:           "otro@larix.cl",
+		AuthenticatedSenders: []string{"otro@larix.cl"},
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, mailer)
+}
+
+func TestQRApp_ProcessRawEmailHelp(t *testing.T) {
+	t.Parallel()
+
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr+svg@mydomain.com").
+		Subject("help").
+		Header("Message-Id", "<help@larix.cl>").
+		Text([]byte("cómo se usa?")).
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// the reply describes every content type, mode, option and command of the registries
+	mailer := &MockMailer{}
+	described := func(text string) bool {
+		for _, line := range []string{
+			"* .ics (text/calendar): " + attachmentHandlers[1].help,
+			"* qr+big@mydomain.com: size=40",
+			"* qr+private@mydomain.com: keys=random",
+		} {
+			if !strings.Contains(text, line) {
+				return false
+			}
+		}
+		for _, handler := range attachmentHandlers {
+			if !strings.Contains(text, handler.extension+" (") || !strings.Contains(text, ": "+handler.help+"\n") {
+				return false
+			}
+		}
+		for _, handler := range bodyHandlers {
+			if !strings.Contains(text, "* "+handler.name+": "+handler.help+"\n") {
+				return false
+			}
+		}
+		for _, opt := range options {
+			if !strings.Contains(text, "* "+opt.name+"="+opt.values+": "+opt.help+"\n") {
+				return false
+			}
+		}
+		for _, cmd := range commands {
+			if !strings.Contains(text, "* "+strings.Join(cmd.words, " o ")+": "+cmd.help+"\n") {
+				return false
+			}
+		}
+		return true
+	}
+	mailer.On("SendReply", ctxMatcher, "<help@larix.cl>", "qr+svg@mydomain.com", "jorge@larix.cl", "help",
+		mock.MatchedBy(described), mock.MatchedBy(func(html string) bool {
+			return strings.Contains(html, "<li><b>size=1-255</b>: "+options[0].help+"</li>")
+		})).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        &MockStorage{},
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		Modes:          map[string]string{"private": "keys=random", "big": "size=40"},
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr+svg@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, mailer)
+}
+
+func TestQRApp_ProcessRawEmailDeleteShared(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	mailer := &MockMailer{}
+	var replies []string
+	mailer.On("SendReply", ctxMatcher, mock.Anything, "qr@mydomain.com", mock.Anything, mock.Anything, mock.Anything,
+		mock.Anything).Run(func(args mock.Arguments) {
+		replies = append(replies, args.String(5))
+	}).Return(nil)
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		KeyStrategy:    KeyStrategyContentHash,
+		RecordsBucket:  "records",
+	}
+	send := func(from, messageID string, build func(b enmime.MailBuilder) enmime.MailBuilder) {
+		part, err := build(enmime.Builder().From("", from).To("QR", "qr@mydomain.com").Subject("menú").
+			Header("Message-Id", messageID)).Build()
+		require.Nil(t, err)
+		email := &bytes.Buffer{}
+		require.Nil(t, part.Encode(email))
+		err = q.ProcessRawEmail(ctx, email, &EmailMetadata{
+			ReplyFrom:            "qr@mydomain.com",
+			ReturnPath:           from,
+			AuthenticatedSenders: []string{from},
+		})
+		require.Nil(t, err)
+	}
+	publish := func(from, messageID string) {
+		send(from, messageID, func(b enmime.MailBuilder) enmime.MailBuilder {
+			return b.Text([]byte("el menú")).AddAttachment([]byte("%PDF-1.4 menú"), "application/pdf", "menu.pdf")
+		})
+	}
+	reply := func(from, messageID string) {
+		send(from, "<borrar-"+messageID[1:], func(b enmime.MailBuilder) enmime.MailBuilder {
+			return b.Header("In-Reply-To", messageID).Text([]byte("borrar"))
+		})
+	}
+
+	// the same file is published by two senders
+	publish("jorge@larix.cl", "<menu@larix.cl>")
+	publish("otro@larix.cl", "<menu@otro.cl>")
+	files, err := storage.List(ctx, q.FilesBucket, "")
+	require.Nil(t, err)
+	require.Len(t, files, 2)
+	require.Len(t, replies, 2)
+	assert.Contains(t, replies[1], "ya estaba publicado")
+
+	// the first sender deletes it, but it's kept for the other one
+	reply("jorge@larix.cl", "<menu@larix.cl>")
+	require.Len(t, replies, 3)
+	assert.True(t, strings.HasPrefix(replies[2], "No borré estos archivos porque otros correos también los publicaron:\n"),
+		replies[2])
+	remaining, err := storage.List(ctx, q.FilesBucket, "")
+	require.Nil(t, err)
+	assert.ElementsMatch(t, files, remaining)
+
+	// and deleted when the other sender deletes it
+	reply("otro@larix.cl", "<menu@otro.cl>")
+	require.Len(t, replies, 4)
+	assert.True(t, strings.HasPrefix(replies[3], "Borré todo lo publicado para ese correo:\n"), replies[3])
+	remaining, err = storage.List(ctx, q.FilesBucket, "")
+	require.Nil(t, err)
+	assert.Empty(t, remaining)
+	records, err := storage.List(ctx, q.RecordsBucket, "")
+	require.Nil(t, err)
+	assert.Empty(t, records)
+}
@@ -0,0 +1,221 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// logoRatio is the maximum size of the logo, relative to the size of the QR code (without the quiet zone).
+	logoRatio = 0.2
+	// maxCaptionLength is the maximum length of the captions, in runes.
+	maxCaptionLength = 100
+	// maxLogoSide is the maximum width and height of the logos in pixels, checked before decoding them (a small but
+	// highly compressed image can take a lot of memory once decoded).
+	maxLogoSide = 2048
+)
+
+var (
+	captionFont     *opentype.Font
+	captionFontErr  error
+	captionFontOnce sync.Once
+)
+
+// loadCaptionFont parses the bundled font used in the captions (Go Bold), so no system fonts are needed.
+func loadCaptionFont() (*opentype.Font, error) {
+	captionFontOnce.Do(func() {
+		captionFont, captionFontErr = opentype.Parse(gobold.TTF)
+	})
+	return captionFont, captionFontErr
+}
+
+// LoadLogo downloads a logo from a bucket to a temporary file, returning its path (to be used as QROptions.Logo).
+func LoadLogo(ctx context.Context, storage Storage, bucket, key string) (string, error) {
+	tmpFile, err := storage.DownloadToTmpFile(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	defer storage.RemoveTmpFile(ctx, tmpFile)
+	logo := &bytes.Buffer{}
+	_, err = io.Copy(logo, tmpFile)
+	if err != nil {
+		return "", err
+	}
+	_, err = decodeLogo(bytes.NewReader(logo.Bytes()))
+	if err != nil {
+		return "", err
+	}
+	logoFile, err := tmpFileName("logo*" + strings.ToLower(path.Ext(key)))
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(logoFile, logo.Bytes(), 0600)
+	if err != nil {
+		return "", err
+	}
+	return logoFile, nil
+}
+
+// checkLogoSize checks the logo image isn't larger than maxLogoSide, reading only its header.
+func checkLogoSize(r io.Reader) error {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return fmt.Errorf("couldn't decode logo: %s", err)
+	}
+	if config.Width > maxLogoSide || config.Height > maxLogoSide {
+		return fmt.Errorf("the logo is %dx%d pixels, larger than %dx%d", config.Width, config.Height, maxLogoSide,
+			maxLogoSide)
+	}
+	return nil
+}
+
+// decodeLogo decodes the logo image, checking its size first.
+func decodeLogo(r io.ReadSeeker) (image.Image, error) {
+	err := checkLogoSize(r)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	logo, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode logo: %s", err)
+	}
+	return logo, nil
+}
+
+// decorateQR adds the logo, frame and caption of the options to the QR code PNG image in imgFile.
+func decorateQR(imgFile, name string, opts QROptions) error {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		return err
+	}
+	qrImg, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	fg, bg := opts.colors()
+	img := image.NewRGBA(qrImg.Bounds())
+	draw.Draw(img, img.Bounds(), qrImg, qrImg.Bounds().Min, draw.Src)
+	if opts.Logo != "" {
+		err = drawLogo(img, opts.Logo, opts.border(), bg)
+		if err != nil {
+			return err
+		}
+	}
+	var result image.Image = img
+	if opts.framed() {
+		result, err = drawFrame(img, opts.caption(name), fg, bg)
+		if err != nil {
+			return err
+		}
+	}
+	out, err := os.Create(imgFile)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(out, result)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// drawLogo draws the logo in logoFile in the center of the QR code, over a background colored square.
+func drawLogo(img *image.RGBA, logoFile string, border int, bg color.RGBA) error {
+	f, err := os.Open(logoFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	logo, err := decodeLogo(f)
+	if err != nil {
+		return err
+	}
+	// scale the logo to fit in the center of the QR code, keeping its aspect ratio
+	maxSize := float64(img.Bounds().Dx()-2*border) * logoRatio
+	logoBounds := logo.Bounds()
+	scale := maxSize / float64(logoBounds.Dx())
+	if s := maxSize / float64(logoBounds.Dy()); s < scale {
+		scale = s
+	}
+	width, height := int(float64(logoBounds.Dx())*scale), int(float64(logoBounds.Dy())*scale)
+	center := image.Pt(img.Bounds().Dx()/2, img.Bounds().Dy()/2)
+	dst := image.Rect(center.X-width/2, center.Y-height/2, center.X-width/2+width, center.Y-height/2+height)
+	// leave some space between the modules and the logo
+	padding := int(maxSize / 20)
+	draw.Draw(img, dst.Inset(-padding), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(img, dst, logo, logoBounds, draw.Over, nil)
+	return nil
+}
+
+// drawFrame returns the QR code inside a frame in the foreground color, with the caption below it.
+func drawFrame(qrImg image.Image, caption string, fg, bg color.RGBA) (image.Image, error) {
+	size := qrImg.Bounds().Dx()
+	thickness := size / 40
+	if thickness < 4 {
+		thickness = 4
+	}
+	captionHeight := size / 6
+	img := image.NewRGBA(image.Rect(0, 0, size+2*thickness, size+2*thickness+captionHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(fg), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(thickness, thickness, thickness+size, thickness+size), qrImg, qrImg.Bounds().Min, draw.Src)
+	// caption centered below the QR code, as big as possible
+	ttf, err := loadCaptionFont()
+	if err != nil {
+		return nil, err
+	}
+	fontSize := float64(captionHeight) * 0.6
+	var face font.Face
+	for {
+		face, err = opentype.NewFace(ttf, &opentype.FaceOptions{Size: fontSize, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		if font.MeasureString(face, caption).Ceil() <= size-2*thickness || fontSize <= 8 {
+			break
+		}
+		face.Close()
+		fontSize *= 0.9
+	}
+	defer face.Close()
+	caption = fitCaption(face, caption, size-2*thickness)
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(bg), Face: face}
+	metrics := face.Metrics()
+	baseline := thickness + size + (captionHeight+metrics.Ascent.Ceil()-metrics.Descent.Ceil())/2
+	d.Dot = fixed.P((img.Bounds().Dx()-d.MeasureString(caption).Ceil())/2, baseline)
+	d.DrawString(caption)
+	return img, nil
+}
+
+// fitCaption shortens the caption with an ellipsis to fit in the given width.
+func fitCaption(face font.Face, caption string, width int) string {
+	if font.MeasureString(face, caption).Ceil() <= width {
+		return caption
+	}
+	runes := []rune(caption)
+	for len(runes) > 0 && font.MeasureString(face, string(runes)+"…").Ceil() > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,200 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQRApp_generateQRDecorated(t *testing.T) {
+	t.Parallel()
+
+	// a red logo
+	dir := t.TempDir()
+	logoFile := filepath.Join(dir, "logo.png")
+	logo := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 50; y++ {
+			logo.Set(x, y, color.RGBA{R: 0xff, A: 0xff})
+		}
+	}
+	f, err := os.Create(logoFile)
+	require.Nil(t, err)
+	require.Nil(t, png.Encode(f, logo))
+	require.Nil(t, f.Close())
+
+	q := &QRApp{}
+	opts := QROptions{ModuleWidth: 10, Border: 20, Foreground: "#003366", Frame: newBool(true), Logo: logoFile}
+	plain := filepath.Join(dir, "plain.png")
+	// (the logo raises the error correction level to H)
+	err = q.generateQR("http://qr.mydomain.com/menu.pdf", "menu.pdf", "", plain, QROptions{ModuleWidth: 10, Border: 20,
+		ErrorCorrection: "H"})
+	require.Nil(t, err)
+	decorated := filepath.Join(dir, "decorated.png")
+	err = q.generateQR("http://qr.mydomain.com/menu.pdf", "menu.pdf", "", decorated, opts)
+	require.Nil(t, err)
+
+	plainImg := decodePNG(t, plain)
+	img := decodePNG(t, decorated)
+	// the frame surrounds the QR code, with the caption below it
+	size := plainImg.Bounds().Dx()
+	thickness := size / 40
+	assert.Equal(t, size+2*thickness, img.Bounds().Dx())
+	assert.Equal(t, size+2*thickness+size/6, img.Bounds().Dy())
+	fg := color.RGBA{R: 0x00, G: 0x33, B: 0x66, A: 0xff}
+	assert.Equal(t, fg, color.RGBAModel.Convert(img.At(0, 0)))
+	assert.Equal(t, fg, color.RGBAModel.Convert(img.At(img.Bounds().Dx()-1, img.Bounds().Dy()-1)))
+	// white quiet zone inside the frame
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.RGBAModel.Convert(img.At(thickness+1, thickness+1)))
+	// the logo in the center of the QR code
+	center := thickness + size/2
+	assert.Equal(t, color.RGBA{R: 0xff, A: 0xff}, color.RGBAModel.Convert(img.At(center, center)))
+	// the caption is drawn in the background color
+	whitePixels := 0
+	for x := 0; x < img.Bounds().Dx(); x++ {
+		for y := thickness + size; y < img.Bounds().Dy(); y++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r == 0xffff {
+				whitePixels++
+			}
+		}
+	}
+	assert.Greater(t, whitePixels, 100)
+}
+
+func TestQROptions_caption(t *testing.T) {
+	assert.Equal(t, "Escanéame: menu.pdf", QROptions{Frame: newBool(true)}.caption("menu.pdf"))
+	assert.Equal(t, "Menú del día", QROptions{Caption: "Menú del día"}.caption("menu.pdf"))
+}
+
+func decodePNG(t *testing.T, name string) image.Image {
+	f, err := os.Open(name)
+	require.Nil(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	require.Nil(t, err)
+	return img
+}
+
+func TestQRApp_ProcessRawEmailWithLogo(t *testing.T) {
+	t.Parallel()
+
+	// email with a document and a red logo
+	logo := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(logo, logo.Bounds(), image.NewUniform(color.RGBA{R: 0xff, A: 0xff}), image.Point{}, draw.Src)
+	logoPNG := &bytes.Buffer{}
+	require.Nil(t, png.Encode(logoPNG, logo))
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("menú").
+		Header("Message-Id", "<menu@larix.cl>").
+		Text([]byte("el menú con logo")).
+		AddAttachment([]byte("%PDF-1.4 menú"), "application/pdf", "menu.pdf").
+		AddAttachment(logoPNG.Bytes(), "image/png", "Logo.png").
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// mock attachment and qr uploading to files bucket, the logo isn't published
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf", "application/pdf", mock.Anything).Return(nil)
+	withLogo := func(f *os.File) bool {
+		_, err := f.Seek(0, io.SeekStart)
+		if err != nil {
+			return false
+		}
+		img, err := png.Decode(f)
+		if err != nil {
+			return false
+		}
+		center := img.Bounds().Dx() / 2
+		return color.RGBAModel.Convert(img.At(center, center)) == color.RGBA{R: 0xff, A: 0xff}
+	}
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf.qr.png", "image/png", mock.MatchedBy(withLogo)).Return(nil)
+	// mock email reply
+	mailer := &MockMailer{}
+	expectedTxt := "menu.pdf quedó en http://qr.mydomain.com/menu.pdf. El QR está en http://qr.mydomain.com/menu.pdf.qr.png."
+	mailer.On("SendReply", ctxMatcher, "<menu@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "menú", expectedTxt,
+		mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_ProcessRawEmailWithHugeLogo(t *testing.T) {
+	t.Parallel()
+
+	// email with a document and a logo too wide, small once compressed
+	logoPNG := &bytes.Buffer{}
+	require.Nil(t, png.Encode(logoPNG, image.NewGray(image.Rect(0, 0, 5000, 1))))
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("menú").
+		Header("Message-Id", "<menu@larix.cl>").
+		Text([]byte("el menú con logo")).
+		AddAttachment([]byte("%PDF-1.4 menú"), "application/pdf", "menu.pdf").
+		AddAttachment(logoPNG.Bytes(), "image/png", "Logo.png").
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// mock attachment and qr uploading to files bucket, the QR is published without the logo
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf.qr.png", "image/png", mock.Anything).Return(nil)
+	// mock email reply
+	mailer := &MockMailer{}
+	expectedTxt := "menu.pdf quedó en http://qr.mydomain.com/menu.pdf. El QR está en http://qr.mydomain.com/menu.pdf.qr.png." +
+		"\n\nOjo, no entendí todas las opciones:\n* no usé Logo.png como logo: the logo is 5000x1 pixels, larger than " +
+		"2048x2048\n"
+	mailer.On("SendReply", ctxMatcher, "<menu@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "menú", expectedTxt,
+		mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
@@ -9,12 +9,17 @@ require (
 	github.com/aws/aws-sdk-go-v2/feature/s3/manager v1.11.7
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.26.7
 	github.com/aws/aws-sdk-go-v2/service/ses v1.14.4
+	github.com/emersion/go-smtp v0.16.0
+	github.com/go-pdf/fpdf v0.8.0
 	github.com/gosimple/slug v1.12.0
 	github.com/jhillyerd/enmime v0.9.3
+	github.com/makiuchi-d/gozxing v0.1.1
 	github.com/psanford/memfs v0.0.0-20210214183328-a001468d78ef
 	github.com/stretchr/testify v1.7.1
 	github.com/yeqown/go-qrcode/v2 v2.2.1
 	github.com/yeqown/go-qrcode/writer/standard v1.2.1
+	golang.org/x/image v0.6.0
+	golang.org/x/net v0.6.0
 )
 
 require (
@@ -34,6 +39,7 @@ require (
 	github.com/aws/smithy-go v1.11.2 // indirect
 	github.com/cention-sany/utf7 v0.0.0-20170124080048-26cad61bd60a // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21 // indirect
 	github.com/fogleman/gg v1.3.0 // indirect
 	github.com/gogs/chardet v0.0.0-20191104214054-4b6791f73a28 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
@@ -48,8 +54,7 @@ require (
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf // indirect
 	github.com/stretchr/objx v0.1.0 // indirect
 	github.com/yeqown/reedsolomon v1.0.0 // indirect
-	golang.org/x/image v0.0.0-20220413100746-70e8d0d3baa9 // indirect
-	golang.org/x/net v0.0.0-20210501142056-aec3718b3fa0 // indirect
-	golang.org/x/text v0.3.6 // indirect
+	golang.org/x/text v0.8.0 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
 )
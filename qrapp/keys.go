@@ -0,0 +1,124 @@
+package qrapp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+)
+
+// KeyStrategy decides the key of every published attachment. The key of the QR code is derived from it.
+type KeyStrategy int
+
+const (
+	// KeyStrategyName uses the file name, so files with the same name overwrite each other: menu.pdf
+	KeyStrategyName KeyStrategy = iota
+	// KeyStrategyMessageID prefixes the file name with the ID of the email: 87vgi822k1hni48use2q/menu.pdf
+	KeyStrategyMessageID
+	// KeyStrategyDate prefixes the file name with the date the email was received: 2022/04/30/menu.pdf
+	KeyStrategyDate
+	// KeyStrategyContentHash uses the SHA-256 of the file contents, keeping the extension: 5f3a...9c1e.pdf. The same
+	// file is published only once, reusing the existing file and QR code (so previously printed codes stay valid).
+	KeyStrategyContentHash
+	// KeyStrategyRandom prefixes the file name with a random unguessable ID: 4xq7...ka2m/menu.pdf
+	KeyStrategyRandom
+)
+
+var keyStrategyNames = map[string]KeyStrategy{
+	"name":         KeyStrategyName,
+	"message-id":   KeyStrategyMessageID,
+	"date":         KeyStrategyDate,
+	"content-hash": KeyStrategyContentHash,
+	"random":       KeyStrategyRandom,
+}
+
+// ParseKeyStrategy returns the KeyStrategy with the given name: name, message-id, date, content-hash or random.
+func ParseKeyStrategy(name string) (KeyStrategy, error) {
+	ks, ok := keyStrategyNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown key strategy %q", name)
+	}
+	return ks, nil
+}
+
+// attachmentKey returns the key to publish the attachment of the given email.
+func (ks KeyStrategy) attachmentKey(meta *EmailMetadata, attachment *enmime.Part) (string, error) {
+	return ks.key(meta, fileNameSlug(attachment.FileName), attachment.Content)
+}
+
+// urlKey returns the key to publish the QR code (adding the QR code extension) of an URL found in the given email.
+func (ks KeyStrategy) urlKey(meta *EmailMetadata, u string) (string, error) {
+	return ks.key(meta, urlSlug(u), []byte(u))
+}
+
+// key returns the key to publish a file with the given name (already slugified) and content.
+func (ks KeyStrategy) key(meta *EmailMetadata, name string, content []byte) (string, error) {
+	switch ks {
+	case KeyStrategyName:
+		return name, nil
+	case KeyStrategyMessageID:
+		return meta.ID + "/" + name, nil
+	case KeyStrategyDate:
+		return meta.Timestamp.UTC().Format("2006/01/02") + "/" + name, nil
+	case KeyStrategyContentHash:
+		hash := sha256.Sum256(content)
+		return hex.EncodeToString(hash[:]) + strings.ToLower(filepath.Ext(name)), nil
+	case KeyStrategyRandom:
+		id, err := randomID()
+		if err != nil {
+			return "", err
+		}
+		return id + "/" + name, nil
+	default:
+		return "", fmt.Errorf("unknown key strategy %d", ks)
+	}
+}
+
+// qrImageKey returns the key to publish the QR code of the file (or URL) published with the given key. With content
+// addressed keys the QR codes are reused, so the key also depends on the options rendering them (unless they're the
+// default ones): the same file sent with other options gets its own QR code.
+func (ks KeyStrategy) qrImageKey(key, name, bkgImg string, opts QROptions) (string, error) {
+	if ks == KeyStrategyContentHash {
+		fingerprint, err := opts.fingerprint(name, bkgImg)
+		if err != nil {
+			return "", err
+		}
+		if fingerprint != "" {
+			return key + ".qr-" + fingerprint + "." + opts.format(), nil
+		}
+	}
+	return key + ".qr." + opts.format(), nil
+}
+
+// sheetKey returns the key to publish the sheet with all the QR codes of the given email.
+func (ks KeyStrategy) sheetKey(meta *EmailMetadata) (string, error) {
+	switch ks {
+	case KeyStrategyDate:
+		return meta.Timestamp.UTC().Format("2006/01/02") + "/" + meta.ID + ".qr.pdf", nil
+	case KeyStrategyRandom:
+		id, err := randomID()
+		if err != nil {
+			return "", err
+		}
+		return id + "/qr.pdf", nil
+	default:
+		return meta.ID + ".qr.pdf", nil
+	}
+}
+
+var randomIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// randomID returns a random ID with 128 bits of entropy.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return randomIDEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,246 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyStrategy_attachmentKey(t *testing.T) {
+	meta := &EmailMetadata{
+		ID:        "87vgi822k1hni48use2qjakorsv84s8m9ug34301",
+		Timestamp: time.Date(2022, 4, 30, 22, 51, 47, 0, time.UTC),
+	}
+	attachment := &enmime.Part{
+		FileName: "Menú.PDF",
+		Content:  []byte("hola"),
+	}
+	tests := []struct {
+		name     string
+		strategy KeyStrategy
+		want     string
+	}{
+		{
+			name:     "name",
+			strategy: KeyStrategyName,
+			want:     "^menu.PDF$",
+		},
+		{
+			name:     "message-id",
+			strategy: KeyStrategyMessageID,
+			want:     "^87vgi822k1hni48use2qjakorsv84s8m9ug34301/menu.PDF$",
+		},
+		{
+			name:     "date",
+			strategy: KeyStrategyDate,
+			want:     "^2022/04/30/menu.PDF$",
+		},
+		{
+			name:     "content-hash",
+			strategy: KeyStrategyContentHash,
+			want:     "^b221d9dbb083a7f33428d7c2a3c3198ae925614d70210e28716ccaa7cd4ddb79.pdf$",
+		},
+		{
+			name:     "random",
+			strategy: KeyStrategyRandom,
+			want:     "^[a-z2-7]{26}/menu.PDF$",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := ParseKeyStrategy(tt.name)
+			require.Nil(t, err)
+			assert.Equal(t, tt.strategy, strategy)
+			key, err := tt.strategy.attachmentKey(meta, attachment)
+			require.Nil(t, err)
+			assert.Regexp(t, regexp.MustCompile(tt.want), key)
+		})
+	}
+	_, err := ParseKeyStrategy("whatever")
+	assert.NotNil(t, err)
+}
+
+func TestQRApp_HandlerKeyStrategyDate(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+	expectedEmailBucket := msg.Receipt.Action.BucketName
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedSubject := msg.Mail.CommonHeaders.Subject
+	expectedEmailAddr := msg.Receipt.Recipients[0]
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, expectedEmailBucket, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// mock attachment and qr uploading to files bucket (in a folder with the date of the email)
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "2022/04/30/historia-social-el-circo.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "2022/04/30/historia-social-el-circo.pdf.qr.png", "image/png", mock.Anything).Return(nil)
+	// mock email reply
+	mailer := &MockMailer{}
+	expectedTxt := "historia-social-el-circo.pdf quedó en http://qr.mydomain.com/2022/04/30/historia-social-el-circo.pdf. El QR está en http://qr.mydomain.com/2022/04/30/historia-social-el-circo.pdf.qr.png."
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, expectedEmailAddr, expectedReturnPath, expectedSubject,
+		expectedTxt, mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+		KeyStrategy:    KeyStrategyDate,
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_HandlerDeduplication(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedSubject := msg.Mail.CommonHeaders.Subject
+	expectedEmailAddr := msg.Receipt.Recipients[0]
+
+	// copy the email to the local emails bucket
+	storage := &LocalStorage{Dir: t.TempDir()}
+	email, err := os.Open(filepath.Join("testdata", msg.Receipt.Action.ObjectKey))
+	require.Nil(t, err)
+	defer email.Close()
+	err = storage.Upload(context.Background(), msg.Receipt.Action.BucketName, msg.Receipt.Action.ObjectKey, "", email)
+	require.Nil(t, err)
+	// mock email replies (the second time the same file is already published)
+	mailer := &MockMailer{}
+	expectedURL := "http://qr.mydomain.com/7a3797933f21fcd9d01e3f4c499bcab1b99b48fea5aa7b7298f676c9bdaedd45.pdf"
+	var replies []string
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, expectedEmailAddr, expectedReturnPath, expectedSubject,
+		mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		replies = append(replies, args.String(5))
+	}).Return(nil).Twice()
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		KeyStrategy:    KeyStrategyContentHash,
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+	require.Len(t, replies, 2)
+	// the key of the QR code has the fingerprint of its options (the background image of the email)
+	expectedQRURL := expectedURL + ".qr-4d7785d9.png"
+	assert.Equal(t, "historia-social-el-circo.pdf quedó en "+expectedURL+". El QR está en "+expectedQRURL+".", replies[0])
+	assert.Equal(t, "historia-social-el-circo.pdf ya estaba publicado en "+expectedURL+". El QR está en "+expectedQRURL+".", replies[1])
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, mailer)
+}
+
+func TestKeyStrategy_qrImageKey(t *testing.T) {
+	key := "b221d9dbb083a7f33428d7c2a3c3198ae925614d70210e28716ccaa7cd4ddb79.pdf"
+	// the default options keep the plain key
+	got, err := KeyStrategyContentHash.qrImageKey(key, "menu.pdf", "", QROptions{})
+	require.Nil(t, err)
+	assert.Equal(t, key+".qr.png", got)
+	got, err = KeyStrategyContentHash.qrImageKey(key, "menu.pdf", "", QROptions{Format: "svg", Border: defaultBorder})
+	require.Nil(t, err)
+	assert.Equal(t, key+".qr.svg", got)
+	// other options get their own QR code
+	withSize, err := KeyStrategyContentHash.qrImageKey(key, "menu.pdf", "", QROptions{ModuleWidth: 10})
+	require.Nil(t, err)
+	assert.Regexp(t, "^"+regexp.QuoteMeta(key)+`\.qr-[0-9a-f]{8}\.png$`, withSize)
+	withColor, err := KeyStrategyContentHash.qrImageKey(key, "menu.pdf", "", QROptions{Foreground: "#036"})
+	require.Nil(t, err)
+	assert.NotEqual(t, withSize, withColor)
+	// framed QR codes have the name of the file in the caption
+	framed, err := KeyStrategyContentHash.qrImageKey(key, "menu.pdf", "", QROptions{Frame: newBool(true)})
+	require.Nil(t, err)
+	framedOtherName, err := KeyStrategyContentHash.qrImageKey(key, "carta.pdf", "", QROptions{Frame: newBool(true)})
+	require.Nil(t, err)
+	assert.NotEqual(t, framed, framedOtherName)
+	// with the other strategies the QR code is always published again
+	got, err = KeyStrategyName.qrImageKey("menu.pdf", "menu.pdf", "", QROptions{ModuleWidth: 10})
+	require.Nil(t, err)
+	assert.Equal(t, "menu.pdf.qr.png", got)
+}
+
+func TestQRApp_ProcessRawEmailContentHashSharedFile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	mailer := &MockMailer{}
+	mailer.On("SendReply", ctxMatcher, mock.Anything, "qr@mydomain.com", "jorge@larix.cl", mock.Anything,
+		mock.Anything, mock.Anything).Return(nil)
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		KeyStrategy:    KeyStrategyContentHash,
+	}
+	sendMenu := func(subject string) {
+		part, err := enmime.Builder().
+			From("Jorge", "jorge@larix.cl").
+			To("QR", "qr@mydomain.com").
+			Subject(subject).
+			Text([]byte("el menú")).
+			AddAttachment([]byte("%PDF-1.4 menú"), "application/pdf", "menu.pdf").
+			Build()
+		require.Nil(t, err)
+		email := &bytes.Buffer{}
+		require.Nil(t, part.Encode(email))
+		err = q.ProcessRawEmail(ctx, email, &EmailMetadata{
+			ReplyFrom:  "qr@mydomain.com",
+			ReturnPath: "jorge@larix.cl",
+		})
+		require.Nil(t, err)
+	}
+	attachmentKey, err := KeyStrategyContentHash.key(nil, "menu.pdf", []byte("%PDF-1.4 menú"))
+	require.Nil(t, err)
+
+	// the file is published, and sent again with other options
+	sendMenu("menú")
+	sendMenu("menú size=10")
+	files, err := storage.List(ctx, q.FilesBucket, "")
+	require.Nil(t, err)
+	assert.Len(t, files, 3)
+	assert.Contains(t, files, attachmentKey)
+	// a QR code that can't be read doesn't remove the file already published
+	sendMenu("menú color=#ffffff size=12")
+	exists, err := storage.Exists(ctx, q.FilesBucket, attachmentKey)
+	require.Nil(t, err)
+	assert.True(t, exists)
+	files, err = storage.List(ctx, q.FilesBucket, "")
+	require.Nil(t, err)
+	assert.Len(t, files, 3)
+}
@@ -0,0 +1,192 @@
+package qrapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localMetadataSuffix is appended to the object path to get the sidecar file holding the object metadata.
+const localMetadataSuffix = ".meta.json"
+
+// LocalStorage is a Storage backed by the local filesystem, every bucket is a directory inside Dir. It's meant to run
+// QRApp on a laptop or a CI box without AWS.
+type LocalStorage struct {
+	Dir string
+}
+
+type localObjectMetadata struct {
+	ContentType string `json:"contentType"`
+}
+
+func (ls *LocalStorage) DownloadToTmpFile(ctx context.Context, bucket, key string) (fs.File, error) {
+	objectPath, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	object, err := os.Open(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s from %s: %s", key, bucket, err)
+	}
+	defer object.Close()
+	tmpFile, err := os.CreateTemp("", "email")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create tmp file: %s", err)
+	}
+	_, err = io.Copy(tmpFile, object)
+	if err == nil {
+		_, err = tmpFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return nil, fmt.Errorf("couldn't copy %s from %s: %s", key, bucket, err)
+	}
+	return tmpFile, nil
+}
+
+func (ls *LocalStorage) RemoveTmpFile(ctx context.Context, tmpFile fs.File) error {
+	if file, ok := tmpFile.(*os.File); ok {
+		file.Close()
+		return os.Remove(file.Name())
+	}
+	return errors.New("unexpected file type")
+}
+
+func (ls *LocalStorage) Exists(ctx context.Context, bucket, key string) (bool, error) {
+	objectPath, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (ls *LocalStorage) Upload(ctx context.Context, bucket, key, contentType string, r io.Reader) error {
+	objectPath, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(objectPath), 0755)
+	if err != nil {
+		return err
+	}
+	object, err := os.Create(objectPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(object, r)
+	closeErr := object.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	// store the content type in a sidecar file
+	metadata, err := json.Marshal(&localObjectMetadata{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(objectPath+localMetadataSuffix, metadata, 0644)
+}
+
+func (ls *LocalStorage) Delete(ctx context.Context, bucket, key string) error {
+	objectPath, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	// like S3, deleting a missing object isn't an error
+	for _, p := range []string{objectPath, objectPath + localMetadataSuffix} {
+		err := os.Remove(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ls *LocalStorage) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	bucketDir, err := ls.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	err = filepath.WalkDir(bucketDir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == bucketDir {
+			// like S3, an empty bucket has no objects
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, localMetadataSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ContentType returns the content type given when the object was uploaded.
+func (ls *LocalStorage) ContentType(bucket, key string) (string, error) {
+	objectPath, err := ls.objectPath(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(objectPath + localMetadataSuffix)
+	if err != nil {
+		return "", err
+	}
+	metadata := &localObjectMetadata{}
+	err = json.Unmarshal(b, metadata)
+	if err != nil {
+		return "", err
+	}
+	return metadata.ContentType, nil
+}
+
+// objectPath maps a bucket and key to a path inside Dir, rejecting keys escaping the bucket directory.
+func (ls *LocalStorage) objectPath(bucket, key string) (string, error) {
+	bucketDir, err := ls.bucketDir(bucket)
+	if err != nil {
+		return "", err
+	}
+	objectPath := filepath.Join(bucketDir, filepath.FromSlash(key))
+	if !strings.HasPrefix(objectPath, bucketDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return objectPath, nil
+}
+
+// bucketDir returns the directory of the bucket inside Dir.
+func (ls *LocalStorage) bucketDir(bucket string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket %q", bucket)
+	}
+	return filepath.Join(ls.Dir, bucket), nil
+}
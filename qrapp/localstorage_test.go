@@ -0,0 +1,115 @@
+package qrapp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+
+	// upload and download an object
+	err := storage.Upload(ctx, "bucket", "dir/file.txt", "text/plain", strings.NewReader("hola"))
+	require.Nil(t, err)
+	exists, err := storage.Exists(ctx, "bucket", "dir/file.txt")
+	require.Nil(t, err)
+	assert.True(t, exists)
+	contentType, err := storage.ContentType("bucket", "dir/file.txt")
+	require.Nil(t, err)
+	assert.Equal(t, "text/plain", contentType)
+	tmpFile, err := storage.DownloadToTmpFile(ctx, "bucket", "dir/file.txt")
+	require.Nil(t, err)
+	b, err := io.ReadAll(tmpFile)
+	require.Nil(t, err)
+	assert.Equal(t, "hola", string(b))
+	err = storage.RemoveTmpFile(ctx, tmpFile)
+	assert.Nil(t, err)
+
+	// list the objects with a prefix, without the metadata files
+	err = storage.Upload(ctx, "bucket", "dir/other.txt", "text/plain", strings.NewReader("chao"))
+	require.Nil(t, err)
+	err = storage.Upload(ctx, "bucket", "top.txt", "text/plain", strings.NewReader("hola"))
+	require.Nil(t, err)
+	keys, err := storage.List(ctx, "bucket", "dir/")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"dir/file.txt", "dir/other.txt"}, keys)
+	keys, err = storage.List(ctx, "empty", "")
+	require.Nil(t, err)
+	assert.Empty(t, keys)
+
+	// delete the object (twice, deleting a missing object isn't an error)
+	err = storage.Delete(ctx, "bucket", "dir/file.txt")
+	assert.Nil(t, err)
+	err = storage.Delete(ctx, "bucket", "dir/file.txt")
+	assert.Nil(t, err)
+	exists, err = storage.Exists(ctx, "bucket", "dir/file.txt")
+	require.Nil(t, err)
+	assert.False(t, exists)
+	_, err = storage.DownloadToTmpFile(ctx, "bucket", "dir/file.txt")
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(storage.Dir, "bucket", "dir", "file.txt"+localMetadataSuffix))
+	assert.True(t, os.IsNotExist(err))
+
+	// keys can't escape the bucket directory
+	err = storage.Upload(ctx, "bucket", "../other/file.txt", "text/plain", strings.NewReader("hola"))
+	assert.NotNil(t, err)
+	err = storage.Upload(ctx, "../bucket", "file.txt", "text/plain", strings.NewReader("hola"))
+	assert.NotNil(t, err)
+}
+
+func TestQRApp_HandlerLocalStorage(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedSubject := msg.Mail.CommonHeaders.Subject
+	expectedEmailAddr := msg.Receipt.Recipients[0]
+
+	// copy the email to the local emails bucket
+	storage := &LocalStorage{Dir: t.TempDir()}
+	email, err := os.Open(filepath.Join("testdata", msg.Receipt.Action.ObjectKey))
+	require.Nil(t, err)
+	defer email.Close()
+	err = storage.Upload(context.Background(), msg.Receipt.Action.BucketName, msg.Receipt.Action.ObjectKey, "", email)
+	require.Nil(t, err)
+	// mock email reply
+	mailer := &MockMailer{}
+	expectedTxt := "historia-social-el-circo.pdf quedó en http://qr.mydomain.com/historia-social-el-circo.pdf. El QR está en http://qr.mydomain.com/historia-social-el-circo.pdf.qr.png."
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, expectedEmailAddr, expectedReturnPath, expectedSubject,
+		expectedTxt, mock.Anything).Return(nil)
+
+	// SUT
+	filesBucket := "qr.mydomain.com"
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+	contentType, err := storage.ContentType(filesBucket, "historia-social-el-circo.pdf")
+	assert.Nil(t, err)
+	assert.Equal(t, "application/pdf", contentType)
+	contentType, err = storage.ContentType(filesBucket, "historia-social-el-circo.pdf.qr.png")
+	assert.Nil(t, err)
+	assert.Equal(t, "image/png", contentType)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, mailer)
+}
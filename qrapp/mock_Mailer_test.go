@@ -26,3 +26,17 @@ func (_m *MockMailer) SendReply(ctx context.Context, messageID string, from stri
 
 	return r0
 }
+
+// SendReplyWithAttachments provides a mock function with given fields: ctx, messageID, from, to, subject, text, html, attachments
+func (_m *MockMailer) SendReplyWithAttachments(ctx context.Context, messageID string, from string, to string, subject string, text string, html string, attachments []MailAttachment) error {
+	ret := _m.Called(ctx, messageID, from, to, subject, text, html, attachments)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, string, string, string, []MailAttachment) error); ok {
+		r0 = rf(ctx, messageID, from, to, subject, text, html, attachments)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -53,6 +53,50 @@ func (_m *MockStorage) DownloadToTmpFile(ctx context.Context, bucket string, key
 	return r0, r1
 }
 
+// Exists provides a mock function with given fields: ctx, bucket, key
+func (_m *MockStorage) Exists(ctx context.Context, bucket string, key string) (bool, error) {
+	ret := _m.Called(ctx, bucket, key)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) bool); ok {
+		r0 = rf(ctx, bucket, key)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, bucket, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// List provides a mock function with given fields: ctx, bucket, prefix
+func (_m *MockStorage) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	ret := _m.Called(ctx, bucket, prefix)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []string); ok {
+		r0 = rf(ctx, bucket, prefix)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, bucket, prefix)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // RemoveTmpFile provides a mock function with given fields: ctx, tmpFile
 func (_m *MockStorage) RemoveTmpFile(ctx context.Context, tmpFile fs.File) error {
 	ret := _m.Called(ctx, tmpFile)
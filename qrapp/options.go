@@ -0,0 +1,344 @@
+package qrapp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// RequestOptions are the options to process a single email, given as name=value pairs (e.g. in the subject of the
+// email: "qr size=40 color=#003366 ecc=H").
+type RequestOptions struct {
+	QR QROptions
+	// KeyStrategy decides the keys of the published attachments.
+	KeyStrategy KeyStrategy
+	// Sheet configures the printable sheet with all the QR codes.
+	Sheet SheetOptions
+	// Event decides how calendar events are shared: qr encodes the event in the QR code, page (the default) publishes
+	// the .ics file and a page with the event, pointed by the QR code.
+	Event string
+	// Expires is how long the files are published (forever if zero).
+	Expires time.Duration
+}
+
+// option is an option accepted by ParseOptions.
+type option struct {
+	name string
+	// values describes the accepted values.
+	values string
+	help   string
+	apply  func(value string, opts *RequestOptions) error
+}
+
+// options is the registry of all the options accepted by ParseOptions.
+var options = []*option{
+	{
+		name:   "size",
+		values: "1-255",
+		help:   "ancho en pixeles de cada módulo (cuadradito) del código QR",
+		apply: func(value string, opts *RequestOptions) error {
+			size, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || size == 0 {
+				return fmt.Errorf("debe ser un número entre 1 y 255")
+			}
+			opts.QR.ModuleWidth = uint8(size)
+			return nil
+		},
+	},
+	{
+		name:   "border",
+		values: "1-1000",
+		help:   "ancho en pixeles del margen alrededor del código QR",
+		apply: func(value string, opts *RequestOptions) error {
+			border, err := strconv.Atoi(value)
+			if err != nil || border < 1 || border > 1000 {
+				return fmt.Errorf("debe ser un número entre 1 y 1000")
+			}
+			opts.QR.Border = border
+			return nil
+		},
+	},
+	{
+		name:   "color",
+		values: "#rrggbb",
+		help:   "color de los módulos del código QR",
+		apply: func(value string, opts *RequestOptions) error {
+			if _, err := parseHexColor(value); err != nil {
+				return fmt.Errorf("debe ser un color como #003366")
+			}
+			opts.QR.Foreground = value
+			return nil
+		},
+	},
+	{
+		name:   "background",
+		values: "#rrggbb, transparent",
+		help:   "color del fondo del código QR",
+		apply: func(value string, opts *RequestOptions) error {
+			if value == "transparent" {
+				opts.QR.Transparent = newBool(true)
+				return nil
+			}
+			if _, err := parseHexColor(value); err != nil {
+				return fmt.Errorf("debe ser un color como #ffffff o transparent")
+			}
+			// a color also overrides a transparent background set by the mode or globally
+			opts.QR.Background = value
+			opts.QR.Transparent = newBool(false)
+			return nil
+		},
+	},
+	{
+		name:   "ecc",
+		values: "L, M, Q, H",
+		help:   "nivel de corrección de errores (H resiste más daño, pero genera un código más denso)",
+		apply: func(value string, opts *RequestOptions) error {
+			value = strings.ToUpper(value)
+			if _, ok := errorCorrectionLevels[value]; !ok {
+				return fmt.Errorf("debe ser L, M, Q o H")
+			}
+			opts.QR.ErrorCorrection = value
+			return nil
+		},
+	},
+	{
+		name:   "shape",
+		values: "square, circle",
+		help:   "forma de los módulos del código QR",
+		apply: func(value string, opts *RequestOptions) error {
+			if value != "square" && value != "circle" {
+				return fmt.Errorf("debe ser square o circle")
+			}
+			opts.QR.Shape = value
+			return nil
+		},
+	},
+	{
+		name:   "format",
+		values: "png, svg",
+		help:   "formato de la imagen del código QR (svg se puede imprimir en cualquier tamaño)",
+		apply: func(value string, opts *RequestOptions) error {
+			value = strings.ToLower(value)
+			if value != "png" && value != "svg" {
+				return fmt.Errorf("debe ser png o svg")
+			}
+			opts.QR.Format = value
+			return nil
+		},
+	},
+	{
+		name:   "frame",
+		values: "yes, no",
+		help:   "dibuja un marco alrededor del código QR, con el nombre del archivo abajo",
+		apply: func(value string, opts *RequestOptions) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				opts.QR.Frame = newBool(true)
+			case "no":
+				opts.QR.Frame = newBool(false)
+				opts.QR.Caption = ""
+			default:
+				return fmt.Errorf("debe ser yes o no")
+			}
+			return nil
+		},
+	},
+	{
+		name:   "caption",
+		values: `"texto entre comillas"`,
+		help:   "texto bajo el código QR, dentro del marco",
+		apply: func(value string, opts *RequestOptions) error {
+			if value == "" || utf8.RuneCountInString(value) > maxCaptionLength {
+				return fmt.Errorf("debe tener entre 1 y %d letras", maxCaptionLength)
+			}
+			opts.QR.Caption = value
+			return nil
+		},
+	},
+	{
+		name:   "sheet",
+		values: "a4, letter, no",
+		help:   "genera un PDF para imprimir con todos los códigos QR, en hojas A4 o carta",
+		apply: func(value string, opts *RequestOptions) error {
+			value = strings.ToLower(value)
+			if _, ok := sheetPaperSizes[value]; !ok && value != "no" {
+				return fmt.Errorf("debe ser a4, letter o no")
+			}
+			if value == "no" {
+				value = ""
+			}
+			opts.Sheet.Paper = value
+			return nil
+		},
+	},
+	{
+		name:   "grid",
+		values: "columnasxfilas, ej: 2x3",
+		help:   "cantidad de columnas y filas de códigos QR en cada hoja del PDF (3x4 por omisión)",
+		apply: func(value string, opts *RequestOptions) error {
+			var columns, rows int
+			_, err := fmt.Sscanf(strings.ToLower(value), "%dx%d", &columns, &rows)
+			if err != nil || columns < 1 || columns > maxSheetGrid || rows < 1 || rows > maxSheetGrid {
+				return fmt.Errorf("debe ser columnasxfilas, entre 1x1 y 10x10")
+			}
+			opts.Sheet.Columns = columns
+			opts.Sheet.Rows = rows
+			return nil
+		},
+	},
+	{
+		name:   "event",
+		values: "page, qr",
+		help:   "para los eventos (.ics): page publica una página con el evento, qr guarda el evento dentro del código QR",
+		apply: func(value string, opts *RequestOptions) error {
+			value = strings.ToLower(value)
+			if value != eventModePage && value != eventModeQR {
+				return fmt.Errorf("debe ser page o qr")
+			}
+			opts.Event = value
+			return nil
+		},
+	},
+	{
+		name:   "expires",
+		values: "12h, 7d, 2w, never",
+		help:   "borra los archivos publicados después de esas horas (h), días (d) o semanas (w), never no los borra",
+		apply: func(value string, opts *RequestOptions) error {
+			expiration, err := ParseExpiration(value)
+			if err != nil {
+				return fmt.Errorf("debe ser un número de horas, días o semanas (como 12h, 7d o 2w, hasta un año) o never")
+			}
+			opts.Expires = expiration
+			return nil
+		},
+	},
+	{
+		name:   "keys",
+		values: "name, message-id, date, content-hash, random",
+		help:   "nombre de los archivos publicados (random genera nombres imposibles de adivinar)",
+		apply: func(value string, opts *RequestOptions) error {
+			ks, err := ParseKeyStrategy(strings.ToLower(value))
+			if err != nil {
+				return fmt.Errorf("debe ser name, message-id, date, content-hash o random")
+			}
+			opts.KeyStrategy = ks
+			return nil
+		},
+	},
+}
+
+// optionRegexp matches name=value options, where the value can be quoted to include spaces (also with the curly quotes
+// added by phones).
+var optionRegexp = regexp.MustCompile(`(?:^|\s)([a-zA-Z]+)=("[^"]*"|“[^”]*”|\S*)`)
+
+// ParseOptions parses the name=value options found in s, ignoring any other word. It returns a warning for every
+// unknown option or invalid value.
+func ParseOptions(s string) (RequestOptions, []string) {
+	var opts RequestOptions
+	warnings := opts.Parse(s)
+	return opts, warnings
+}
+
+// Parse parses the name=value options found in s like ParseOptions, overriding the current options.
+func (opts *RequestOptions) Parse(s string) []string {
+	var warnings []string
+	for _, match := range optionRegexp.FindAllStringSubmatch(s, -1) {
+		name, value := strings.ToLower(match[1]), unquote(match[2])
+		opt := findOption(name)
+		if opt == nil {
+			warnings = append(warnings, fmt.Sprintf("no conozco la opción %s", name))
+			continue
+		}
+		err := opt.apply(value, opts)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("el valor de %s %s (%s)", name, err, value))
+		}
+	}
+	return warnings
+}
+
+// unquote removes the quotes around a value.
+func unquote(value string) string {
+	for _, quotes := range [][2]string{{`"`, `"`}, {"“", "”"}} {
+		if len(value) >= len(quotes[0])+len(quotes[1]) && strings.HasPrefix(value, quotes[0]) &&
+			strings.HasSuffix(value, quotes[1]) {
+			return value[len(quotes[0]) : len(value)-len(quotes[1])]
+		}
+	}
+	return value
+}
+
+func findOption(name string) *option {
+	for _, opt := range options {
+		if opt.name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// DefaultModes are the modes used when QRApp.Modes is nil.
+var DefaultModes = map[string]string{
+	"private": "keys=random",
+	"svg":     "format=svg",
+	"pdf":     "sheet=a4",
+}
+
+// ParseModes parses modes given as mode:options pairs separated by semicolons, e.g. "private:keys=random;big:size=40".
+func ParseModes(s string) (map[string]string, error) {
+	modes := make(map[string]string)
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		mode, opts, ok := strings.Cut(pair, ":")
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if !ok || mode == "" {
+			return nil, fmt.Errorf("invalid mode %q (mode:options)", pair)
+		}
+		if _, warnings := ParseOptions(opts); len(warnings) > 0 {
+			return nil, fmt.Errorf("invalid options for mode %s: %s", mode, strings.Join(warnings, ", "))
+		}
+		modes[mode] = opts
+	}
+	return modes, nil
+}
+
+// acceptedRecipient returns the recipient (one of recipients) addr was sent to, keeping the mode of sub-addressed
+// recipients (qr+<mode>@domain) if it's one of modes. Sub-addresses with other modes aren't accepted.
+func acceptedRecipient(recipients []string, modes map[string]string, addr string) (string, bool) {
+	mode := recipientMode(addr)
+	if _, ok := modes[mode]; mode != "" && !ok {
+		return "", false
+	}
+	local, domain, _ := strings.Cut(addr, "@")
+	local, _, _ = strings.Cut(local, "+")
+	base := local + "@" + domain
+	for _, recipient := range recipients {
+		if strings.EqualFold(recipient, base) {
+			if mode != "" {
+				return modeAddress(recipient, mode), true
+			}
+			return recipient, true
+		}
+	}
+	return "", false
+}
+
+// recipientMode returns the mode selected by sub-addressing the recipient (qr+<mode>@domain), or "" if none.
+func recipientMode(addr string) string {
+	local, _, _ := strings.Cut(addr, "@")
+	_, mode, _ := strings.Cut(local, "+")
+	return strings.ToLower(mode)
+}
+
+// modeAddress returns the address selecting mode by sub-addressing addr (qr+<mode>@domain), replacing its mode if any.
+func modeAddress(addr, mode string) string {
+	local, domain, _ := strings.Cut(addr, "@")
+	local, _, _ = strings.Cut(local, "+")
+	return local + "+" + mode + "@" + domain
+}
@@ -0,0 +1,291 @@
+package qrapp
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		s            string
+		wantOpts     RequestOptions
+		wantWarnings []string
+	}{
+		{
+			name: "no options",
+			s:    "código qr",
+		},
+		{
+			name: "all QR options",
+			s:    "qr size=40 border=10 color=#003366 background=#eee ECC=h shape=circle format=SVG",
+			wantOpts: RequestOptions{QR: QROptions{ModuleWidth: 40, Border: 10, Foreground: "#003366",
+				Background: "#eee", Transparent: newBool(false), ErrorCorrection: "H", Shape: "circle", Format: "svg"}},
+		},
+		{
+			name:     "sheet",
+			s:        "qr sheet=Letter grid=2x3 keys=random",
+			wantOpts: RequestOptions{Sheet: SheetOptions{Paper: "letter", Columns: 2, Rows: 3}, KeyStrategy: KeyStrategyRandom},
+		},
+		{
+			name:     "calendar events and expiration",
+			s:        "evento event=QR expires=2w",
+			wantOpts: RequestOptions{Event: "qr", Expires: 14 * 24 * time.Hour},
+		},
+		{
+			name:         "invalid expiration",
+			s:            "qr expires=2y",
+			wantWarnings: []string{"el valor de expires debe ser un número de horas, días o semanas (como 12h, 7d o 2w, hasta un año) o never (2y)"},
+		},
+		{
+			name:     "invalid grid",
+			s:        "qr sheet=a4 grid=20x1",
+			wantOpts: RequestOptions{Sheet: SheetOptions{Paper: "a4"}},
+			wantWarnings: []string{
+				"el valor de grid debe ser columnasxfilas, entre 1x1 y 10x10 (20x1)",
+			},
+		},
+		{
+			name:     "quoted caption",
+			s:        `qr caption="Menú del día" frame=yes size=30`,
+			wantOpts: RequestOptions{QR: QROptions{Caption: "Menú del día", Frame: newBool(true), ModuleWidth: 30}},
+		},
+		{
+			name:     "curly quoted caption",
+			s:        "qr caption=“Escanea el menú”",
+			wantOpts: RequestOptions{QR: QROptions{Caption: "Escanea el menú"}},
+		},
+		{
+			name:     "transparent background",
+			s:        "Re: qr background=transparent",
+			wantOpts: RequestOptions{QR: QROptions{Transparent: newBool(true)}},
+		},
+		{
+			name:     "unknown options and invalid values",
+			s:        "qr size=300 wat=1 color=red ecc=H",
+			wantOpts: RequestOptions{QR: QROptions{ErrorCorrection: "H"}},
+			wantWarnings: []string{
+				"el valor de size debe ser un número entre 1 y 255 (300)",
+				"no conozco la opción wat",
+				"el valor de color debe ser un color como #003366 (red)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, warnings := ParseOptions(tt.s)
+			assert.Equal(t, tt.wantOpts, opts)
+			assert.Equal(t, tt.wantWarnings, warnings)
+		})
+	}
+}
+
+func TestQRApp_HandlerSubjectOptions(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification, with options in the subject
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	msg.Mail.CommonHeaders.Subject = "qr size=40 ecc=H wat=1"
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+	expectedEmailBucket := msg.Receipt.Action.BucketName
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedEmailAddr := msg.Receipt.Recipients[0]
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, expectedEmailBucket, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// mock attachment and qr uploading to files bucket
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf.qr.png", "image/png", mock.Anything).Return(nil)
+	// mock email reply (reporting the unknown option)
+	mailer := &MockMailer{}
+	expectedTxt := `historia-social-el-circo.pdf quedó en http://qr.mydomain.com/historia-social-el-circo.pdf. El QR está en http://qr.mydomain.com/historia-social-el-circo.pdf.qr.png.
+
+Ojo, no entendí todas las opciones:
+* no conozco la opción wat
+`
+	expectedHtml := `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+<html>
+    <head>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+    </head>
+    <body>
+<p><a href="http://qr.mydomain.com/historia-social-el-circo.pdf">historia-social-el-circo.pdf</a>: <a href="http://qr.mydomain.com/historia-social-el-circo.pdf.qr.png">código QR</a>.</p>
+<p>Ojo, no entendí todas las opciones:</p>
+<ul>
+<li>no conozco la opción wat</li>
+</ul>
+    </body>
+</html>`
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, expectedEmailAddr, expectedReturnPath, "qr size=40 ecc=H wat=1",
+		expectedTxt, expectedHtml).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestParseModes(t *testing.T) {
+	modes, err := ParseModes("private:keys=random; big: size=40 ecc=H;")
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"private": "keys=random", "big": " size=40 ecc=H"}, modes)
+
+	_, err = ParseModes("private")
+	assert.NotNil(t, err)
+	_, err = ParseModes("private:keys=secret")
+	assert.NotNil(t, err)
+}
+
+func TestQRApp_HandlerRecipientMode(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification, sent to the private mode
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	msg.Receipt.Recipients[0] = "qr+private@mydomain.com"
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+	expectedEmailBucket := msg.Receipt.Action.BucketName
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedSubject := msg.Mail.CommonHeaders.Subject
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, expectedEmailBucket, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// mock attachment and qr uploading to files bucket, with random keys
+	filesBucket := "qr.mydomain.com"
+	randomKey := regexp.MustCompile(`^[a-z2-7]{26}/historia-social-el-circo\.pdf$`)
+	storage.On("Upload", ctxMatcher, filesBucket, mock.MatchedBy(randomKey.MatchString), "application/pdf", mock.Anything).Return(nil)
+	randomQRKey := regexp.MustCompile(`^[a-z2-7]{26}/historia-social-el-circo\.pdf\.qr\.png$`)
+	storage.On("Upload", ctxMatcher, filesBucket, mock.MatchedBy(randomQRKey.MatchString), "image/png", mock.Anything).Return(nil)
+	// mock email reply, from the sub-address
+	mailer := &MockMailer{}
+	expectedTxt := regexp.MustCompile(`^historia-social-el-circo\.pdf quedó en http://qr\.mydomain\.com/[a-z2-7]{26}/historia-social-el-circo\.pdf\. `)
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, "qr+private@mydomain.com", expectedReturnPath, expectedSubject,
+		mock.MatchedBy(expectedTxt.MatchString), mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_HandlerUnknownRecipientMode(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification, sent to an unknown mode
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	msg.Receipt.Recipients[0] = "qr+wat@mydomain.com"
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, msg.Receipt.Action.BucketName, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// the attachment is published with the default options
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf.qr.png", "image/png", mock.Anything).Return(nil)
+	// mock email reply (reporting the unknown mode)
+	mailer := &MockMailer{}
+	expectedTxt := `historia-social-el-circo.pdf quedó en http://qr.mydomain.com/historia-social-el-circo.pdf. El QR está en http://qr.mydomain.com/historia-social-el-circo.pdf.qr.png.
+
+Ojo, no entendí todas las opciones:
+* no conozco el modo wat
+`
+	mailer.On("SendReply", ctxMatcher, msg.Mail.CommonHeaders.MessageID, "qr+wat@mydomain.com",
+		msg.Mail.CommonHeaders.ReturnPath, msg.Mail.CommonHeaders.Subject, expectedTxt, mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+		Modes:          map[string]string{"big": "size=40"},
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_HandlerRecipients(t *testing.T) {
+	t.Parallel()
+
+	// the receipt rule accepts the whole domain, but only the emails sent to QRApp (and its modes) are processed
+	storage := &MockStorage{}
+	mailer := &MockMailer{}
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		Recipients:     []string{"qr@mydomain.com"},
+	}
+	for _, recipients := range [][]string{{"otro@mydomain.com"}, {"qr+wat@mydomain.com"}, {"qr@otherdomain.com"}} {
+		msg, err := testingMsg("snsemail-with-attachment.json")
+		require.Nil(t, err)
+		msg.Receipt.Recipients = recipients
+		err = q.ProcessEmail(context.Background(), msg)
+		assert.Nil(t, err)
+	}
+	// nothing was downloaded nor replied
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+
+	// the reply is sent from the address of QRApp, keeping the mode
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	msg.Receipt.Recipients = []string{"otro@mydomain.com", "QR+Private@mydomain.com"}
+	msg.Receipt.Action.Type = "SNS"
+	msg.Receipt.Action.Encoding = "UTF8"
+	msg.Content = "From: jorge@larix.cl\r\nSubject: hola\r\n\r\nsin adjuntos\r\n"
+	mailer.On("SendReply", ctxMatcher, mock.Anything, "qr+private@mydomain.com", msg.Mail.CommonHeaders.ReturnPath,
+		mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
@@ -0,0 +1,328 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// publicationsPrefix is the prefix of the keys of the publication records in RecordsBucket.
+	publicationsPrefix = "publications/"
+	// ownersPrefix is the prefix of the references from the published objects to the records publishing them, in
+	// RecordsBucket: owners/<object key>/<record hash>. An object is deleted only when nobody else published it.
+	ownersPrefix = "owners/"
+	// expirationsPrefix is the prefix of the index of the expiring records in RecordsBucket, sorted by expiration:
+	// expirations/<expiration>/<record key>. The expired records are found without reading every record.
+	expirationsPrefix = "expirations/"
+	// expirationLayout is the format of the expirations in the index, sorted like the times they represent.
+	expirationLayout = "20060102T150405Z"
+	// maxExpiration is the maximum time the files can be published with the expires option.
+	maxExpiration = 365 * 24 * time.Hour
+)
+
+// Publication is the record of the objects published in FilesBucket for an email, stored in RecordsBucket.
+type Publication struct {
+	MessageID string    `json:"messageId"`
+	Sender    string    `json:"sender"`
+	Published time.Time `json:"published"`
+	// Expires is when the objects are deleted (never if nil).
+	Expires *time.Time `json:"expires,omitempty"`
+	// Keys of the objects published in FilesBucket: the files, their QR codes, pages and the printable sheet.
+	Keys []string `json:"keys"`
+	// Files describes the published files, to list them.
+	Files []PublishedFile `json:"files"`
+}
+
+// PublishedFile is a file (or URL) published for an email, with its QR code.
+type PublishedFile struct {
+	Name       string `json:"name"`
+	URL        string `json:"url,omitempty"`
+	QRImageURL string `json:"qrImageUrl,omitempty"`
+	// Size of the file in bytes (0 for URLs).
+	Size int64 `json:"size,omitempty"`
+}
+
+// publicationKey returns the key of the record of the email with the given Message-ID, sent by sender.
+func publicationKey(sender, messageID string) string {
+	hash := sha256.Sum256([]byte(messageID))
+	return senderPublicationsPrefix(sender) + hex.EncodeToString(hash[:16]) + ".json"
+}
+
+// objectOwnersPrefix returns the prefix of the references to the records publishing the object with the given key.
+func objectOwnersPrefix(key string) string {
+	return ownersPrefix + url.PathEscape(key) + "/"
+}
+
+// ownerKey returns the key of the reference from the object with the given key to the record with recordKey.
+func ownerKey(key, recordKey string) string {
+	hash := sha256.Sum256([]byte(recordKey))
+	return objectOwnersPrefix(key) + hex.EncodeToString(hash[:16])
+}
+
+// expirationKey returns the key of the entry of the record with recordKey in the index of the expiring records.
+func expirationKey(expires time.Time, recordKey string) string {
+	return expirationsPrefix + expires.UTC().Format(expirationLayout) + "/" + url.PathEscape(recordKey)
+}
+
+// senderPublicationsPrefix returns the prefix of the keys of the records of the emails sent by sender, the index of
+// everything the sender published.
+func senderPublicationsPrefix(sender string) string {
+	return publicationsPrefix + url.PathEscape(strings.ToLower(sender)) + "/"
+}
+
+// senders returns the addresses of the sender of the email, lowercased and without display names: the From addresses
+// and then the return path, which is rewritten by some mailing lists and forwarders (VERP, SRS). The publications are
+// recorded with the first one.
+func (meta *EmailMetadata) senders() []string {
+	senders := normalizeAddresses(append(append([]string{}, meta.From...), meta.ReturnPath))
+	if len(senders) == 0 {
+		senders = append(senders, strings.ToLower(meta.ReturnPath))
+	}
+	return senders
+}
+
+// authenticatedSenders returns the addresses of the sender authenticated by the receiver of the email (see
+// EmailMetadata.AuthenticatedSenders), lowercased and without display names.
+func (meta *EmailMetadata) authenticatedSenders() []string {
+	return normalizeAddresses(meta.AuthenticatedSenders)
+}
+
+// normalizeAddresses returns the addresses lowercased and without display names nor duplicates, skipping the invalid
+// ones.
+func normalizeAddresses(addresses []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, address := range addresses {
+		addr, err := mail.ParseAddress(address)
+		if err != nil {
+			continue
+		}
+		email := strings.ToLower(addr.Address)
+		if !seen[email] {
+			seen[email] = true
+			normalized = append(normalized, email)
+		}
+	}
+	return normalized
+}
+
+// ParseExpiration parses an expiration like the expires option: a number of hours, days or weeks (12h, 7d, 2w) or
+// never (0).
+func ParseExpiration(value string) (time.Duration, error) {
+	value = strings.ToLower(value)
+	if value == "never" {
+		return 0, nil
+	}
+	units := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(value) < 2 {
+		return 0, fmt.Errorf("invalid expiration %q", value)
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid expiration unit %q", value)
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid expiration %q", value)
+	}
+	expiration := time.Duration(n) * unit
+	if expiration > maxExpiration {
+		return 0, fmt.Errorf("expiration %q longer than a year", value)
+	}
+	return expiration, nil
+}
+
+// recordPublication stores the record of the objects published for the request (the successful results and the
+// sheet) in RecordsBucket, returning it. Nothing is stored if nothing was published.
+func (q *QRApp) recordPublication(ctx context.Context, req *request, results []ProcessingResult, sheetKey, sheetURL string) (*Publication, error) {
+	publication := &Publication{
+		MessageID: req.meta.MessageID,
+		Sender:    req.meta.senders()[0],
+		// like the index of the expiring records, the record keeps whole seconds
+		Published: req.meta.Timestamp.UTC().Truncate(time.Second),
+	}
+	// the files already published (see KeyStrategyContentHash) are shared with other publications
+	for _, result := range results {
+		if result.Error != nil || len(result.Keys) == 0 {
+			continue
+		}
+		publication.Keys = append(publication.Keys, result.Keys...)
+		publication.Files = append(publication.Files, PublishedFile{
+			Name:       result.AttachmentName,
+			URL:        result.AttachmentURL,
+			QRImageURL: result.QRImageURL,
+			Size:       result.Size,
+		})
+	}
+	if sheetKey != "" {
+		publication.Keys = append(publication.Keys, sheetKey)
+		publication.Files = append(publication.Files, PublishedFile{Name: "hoja para imprimir", URL: sheetURL})
+	}
+	if len(publication.Keys) == 0 {
+		return nil, nil
+	}
+	if req.options.Expires > 0 {
+		expires := publication.Published.Add(req.options.Expires)
+		publication.Expires = &expires
+	}
+	err := q.savePublication(ctx, publication)
+	if err != nil {
+		return nil, err
+	}
+	return publication, nil
+}
+
+// savePublication stores the record in RecordsBucket, with the references from its objects and its entry in the index
+// of the expiring records.
+func (q *QRApp) savePublication(ctx context.Context, publication *Publication) error {
+	b, err := json.Marshal(publication)
+	if err != nil {
+		return err
+	}
+	key := publicationKey(publication.Sender, publication.MessageID)
+	err = q.Storage.Upload(ctx, q.RecordsBucket, key, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for _, objectKey := range publication.Keys {
+		err = q.Storage.Upload(ctx, q.RecordsBucket, ownerKey(objectKey, key), "text/plain", strings.NewReader(key))
+		if err != nil {
+			return err
+		}
+	}
+	if publication.Expires != nil {
+		err = q.Storage.Upload(ctx, q.RecordsBucket, expirationKey(*publication.Expires, key), "text/plain",
+			strings.NewReader(key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPublication reads the record with the given key from RecordsBucket.
+func (q *QRApp) loadPublication(ctx context.Context, key string) (*Publication, error) {
+	tmpFile, err := q.Storage.DownloadToTmpFile(ctx, q.RecordsBucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Storage.RemoveTmpFile(ctx, tmpFile)
+	publication := &Publication{}
+	err = json.NewDecoder(tmpFile).Decode(publication)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode %s: %s", key, err)
+	}
+	return publication, nil
+}
+
+// deletePublication deletes the objects of the publication from FilesBucket and then its record, returning the keys
+// of the objects kept because other publications also published them.
+func (q *QRApp) deletePublication(ctx context.Context, publication *Publication) ([]string, error) {
+	recordKey := publicationKey(publication.Sender, publication.MessageID)
+	var kept []string
+	for _, key := range publication.Keys {
+		// drop the reference before looking for other ones, so concurrent deletions don't keep the object forever
+		err := q.Storage.Delete(ctx, q.RecordsBucket, ownerKey(key, recordKey))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't delete the reference to %s: %s", key, err)
+		}
+		owners, err := q.Storage.List(ctx, q.RecordsBucket, objectOwnersPrefix(key))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list the references to %s: %s", key, err)
+		}
+		if len(owners) > 0 {
+			log.Printf("keeping %s, published by %d other emails", key, len(owners))
+			kept = append(kept, key)
+			continue
+		}
+		err = q.Storage.Delete(ctx, q.FilesBucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't delete %s from %s: %s", key, q.FilesBucket, err)
+		}
+	}
+	err := q.Storage.Delete(ctx, q.RecordsBucket, recordKey)
+	if err != nil {
+		return nil, err
+	}
+	if publication.Expires != nil {
+		err = q.Storage.Delete(ctx, q.RecordsBucket, expirationKey(*publication.Expires, recordKey))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't delete the expiration of %s: %s", recordKey, err)
+		}
+	}
+	return kept, nil
+}
+
+// DeleteExpired deletes the objects of the publications expired at now from FilesBucket, returning how many
+// publications were deleted. It's meant to be run periodically, reading only the index of the expiring records and the
+// expired ones.
+func (q *QRApp) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	if q.RecordsBucket == "" {
+		return 0, fmt.Errorf("missing RecordsBucket")
+	}
+	entries, err := q.Storage.List(ctx, q.RecordsBucket, expirationsPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't list the expirations: %s", err)
+	}
+	sort.Strings(entries)
+	deleted := 0
+	var lastErr error
+	for _, entry := range entries {
+		expiration, escapedKey, ok := strings.Cut(strings.TrimPrefix(entry, expirationsPrefix), "/")
+		expires, err := time.Parse(expirationLayout, expiration)
+		if !ok || err != nil {
+			log.Printf("invalid expiration %s", entry)
+			continue
+		}
+		if expires.After(now) {
+			// the rest of the index expires later
+			break
+		}
+		key, err := url.PathUnescape(escapedKey)
+		if err != nil {
+			log.Printf("invalid expiration %s: %s", entry, err)
+			continue
+		}
+		publication, err := q.loadPublication(ctx, key)
+		if err != nil {
+			log.Printf("couldn't load publication %s: %s", key, err)
+			lastErr = err
+			continue
+		}
+		if publication.Expires == nil || publication.Expires.UTC().Format(expirationLayout) != expiration {
+			// the record was stored again with another expiration
+			err = q.Storage.Delete(ctx, q.RecordsBucket, entry)
+			if err != nil {
+				log.Printf("couldn't delete expiration %s: %s", entry, err)
+			}
+			continue
+		}
+		_, err = q.deletePublication(ctx, publication)
+		if err != nil {
+			log.Printf("couldn't delete publication %s: %s", key, err)
+			lastErr = err
+			continue
+		}
+		log.Printf("deleted publication %s of %s (expired %s)", publication.MessageID, publication.Sender,
+			publication.Expires.Format(time.RFC3339))
+		deleted++
+	}
+	return deleted, lastErr
+}
+
+// spanishDateTime formats t in the local time zone (TZ): sábado 10 de mayo de 2025 a las 19:00 (UTC)
+func spanishDateTime(t time.Time) string {
+	t = t.In(time.Local)
+	return fmt.Sprintf("%s a las %s (%s)", spanishDate(t), t.Format("15:04"), t.Format("MST"))
+}
@@ -0,0 +1,262 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpiration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "12h", want: 12 * time.Hour},
+		{value: "7D", want: 7 * 24 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "never", want: 0},
+		{value: "52w", want: 52 * 7 * 24 * time.Hour},
+		{value: "54w", wantErr: true},
+		{value: "0d", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "7m", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseExpiration(tt.value)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestQRApp_ProcessRawEmailExpiring(t *testing.T) {
+	t.Parallel()
+
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("menú expires=7d").
+		Header("Message-Id", "<menu@larix.cl>").
+		Text([]byte("el menú de la semana")).
+		AddAttachment([]byte("%PDF-1.4 menú"), "application/pdf", "menu.pdf").
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// the record of the publication is stored in the records bucket
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf.qr.png", "image/png", mock.Anything).Return(nil)
+	// SES timestamps have milliseconds, the record keeps whole seconds
+	received := time.Date(2026, 10, 17, 12, 30, 0, 497*int(time.Millisecond), time.UTC)
+	expires := received.Truncate(time.Second).Add(7 * 24 * time.Hour)
+	expectedPublication := &Publication{
+		MessageID: "<menu@larix.cl>",
+		Sender:    "jorge@larix.cl",
+		Published: received,
+		Expires:   &expires,
+		Keys:      []string{"menu.pdf", "menu.pdf.qr.png"},
+	}
+	withPublication := func(r io.Reader) bool {
+		publication := &Publication{}
+		err := json.NewDecoder(r).Decode(publication)
+		return err == nil && assert.ObjectsAreEqual(expectedPublication.Keys, publication.Keys) &&
+			publication.Expires != nil && publication.Expires.Equal(expires)
+	}
+	recordKey := publicationKey("jorge@larix.cl", "<menu@larix.cl>")
+	storage.On("Upload", ctxMatcher, "records", recordKey, "application/json", mock.MatchedBy(withPublication)).Return(nil)
+	// with the references from the published objects to the record
+	for _, key := range expectedPublication.Keys {
+		storage.On("Upload", ctxMatcher, "records", ownerKey(key, recordKey), "text/plain", mock.Anything).Return(nil)
+	}
+	// and its entry in the index of the expiring records
+	assert.Equal(t, "expirations/20261024T123000Z/"+url.PathEscape(recordKey), expirationKey(expires, recordKey))
+	storage.On("Upload", ctxMatcher, "records", expirationKey(expires, recordKey), "text/plain", mock.Anything).Return(nil)
+	// mock email reply, stating the expiration
+	mailer := &MockMailer{}
+	expectedTxt := "menu.pdf quedó en http://qr.mydomain.com/menu.pdf. El QR está en http://qr.mydomain.com/menu.pdf.qr.png.\n\n" +
+		"Todo lo publicado se borrará el " + spanishDateTime(expires) + "."
+	mailer.On("SendReply", ctxMatcher, "<menu@larix.cl>", "qr@mydomain.com", "Jorge@larix.cl", "menú expires=7d",
+		expectedTxt, mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+		RecordsBucket:  "records",
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		Timestamp:  received,
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "Jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_ProcessRawEmailExpiringWithoutRecords(t *testing.T) {
+	t.Parallel()
+
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("menú").
+		Header("Message-Id", "<menu@larix.cl>").
+		Text([]byte("el menú de la semana")).
+		AddAttachment([]byte("%PDF-1.4 menú"), "application/pdf", "menu.pdf").
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf.qr.png", "image/png", mock.Anything).Return(nil)
+	// the default expiration can't be honored without a records bucket
+	mailer := &MockMailer{}
+	expectedTxt := `menu.pdf quedó en http://qr.mydomain.com/menu.pdf. El QR está en http://qr.mydomain.com/menu.pdf.qr.png.
+
+Ojo, no entendí todas las opciones:
+* no puedo borrar los archivos automáticamente, no tengo dónde anotar cuándo
+`
+	mailer.On("SendReply", ctxMatcher, "<menu@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "menú", expectedTxt,
+		mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+		Expiration:     24 * time.Hour,
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_DeleteExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	q := &QRApp{
+		Storage:       storage,
+		FilesBucket:   "files",
+		RecordsBucket: "records",
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	expired, notExpired := now.Add(-time.Minute), now.Add(time.Minute)
+	publications := []*Publication{
+		{MessageID: "<expired@larix.cl>", Sender: "jorge@larix.cl", Expires: &expired, Keys: []string{"a.pdf", "a.pdf.qr.png", "menu.pdf"}},
+		{MessageID: "<not-expired@larix.cl>", Sender: "jorge@larix.cl", Expires: &notExpired, Keys: []string{"b.pdf"}},
+		{MessageID: "<never@larix.cl>", Sender: "otro@larix.cl", Keys: []string{"c.pdf"}},
+		// another sender published a file with the same name later (see KeyStrategyName)
+		{MessageID: "<menu@otro.cl>", Sender: "otro@otro.cl", Expires: &notExpired, Keys: []string{"menu.pdf"}},
+	}
+	for _, publication := range publications {
+		for _, key := range publication.Keys {
+			require.Nil(t, storage.Upload(ctx, "files", key, "", strings.NewReader(key)))
+		}
+		require.Nil(t, q.savePublication(ctx, publication))
+	}
+
+	// only the expired files and record are deleted, keeping the file published again by the other sender
+	deleted, err := q.DeleteExpired(ctx, now)
+	require.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	files, err := storage.List(ctx, "files", "")
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{"b.pdf", "c.pdf", "menu.pdf"}, files)
+	records, err := storage.List(ctx, "records", publicationsPrefix)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{
+		publicationKey("jorge@larix.cl", "<not-expired@larix.cl>"),
+		publicationKey("otro@larix.cl", "<never@larix.cl>"),
+		publicationKey("otro@otro.cl", "<menu@otro.cl>"),
+	}, records)
+	expirations, err := storage.List(ctx, "records", expirationsPrefix)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{
+		expirationKey(notExpired, publicationKey("jorge@larix.cl", "<not-expired@larix.cl>")),
+		expirationKey(notExpired, publicationKey("otro@otro.cl", "<menu@otro.cl>")),
+	}, expirations)
+
+	// nothing else expired
+	deleted, err = q.DeleteExpired(ctx, now)
+	require.Nil(t, err)
+	assert.Equal(t, 0, deleted)
+}
+
+func TestQRApp_DeleteExpiredLater(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage := &LocalStorage{Dir: t.TempDir()}
+	q := &QRApp{
+		Storage:       storage,
+		FilesBucket:   "files",
+		RecordsBucket: "records",
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	// the same file published by another sender, expiring later (see KeyStrategyName), with sub-second expirations
+	first, second := now.Add(time.Hour+497*time.Millisecond), now.Add(48*time.Hour+10*time.Millisecond)
+	publications := []*Publication{
+		{MessageID: "<menu@larix.cl>", Sender: "jorge@larix.cl", Expires: &first, Keys: []string{"menu.pdf"}},
+		{MessageID: "<menu@otro.cl>", Sender: "otro@otro.cl", Expires: &second, Keys: []string{"menu.pdf"}},
+	}
+	require.Nil(t, storage.Upload(ctx, "files", "menu.pdf", "", strings.NewReader("menu.pdf")))
+	for _, publication := range publications {
+		require.Nil(t, q.savePublication(ctx, publication))
+	}
+
+	// the file is kept while the newer publication references it
+	deleted, err := q.DeleteExpired(ctx, now.Add(2*time.Hour))
+	require.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	files, err := storage.List(ctx, "files", "")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"menu.pdf"}, files)
+
+	// and deleted when it expires too
+	deleted, err = q.DeleteExpired(ctx, now.Add(49*time.Hour))
+	require.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	files, err = storage.List(ctx, "files", "")
+	require.Nil(t, err)
+	assert.Empty(t, files)
+	records, err := storage.List(ctx, "records", "")
+	require.Nil(t, err)
+	assert.Empty(t, records)
+}
@@ -3,19 +3,23 @@ package qrapp
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	htmltpl "html/template"
 	"io"
 	"io/fs"
 	"log"
+	"net/mail"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	txttpl "text/template"
+	"time"
 
 	"github.com/gosimple/slug"
 	"github.com/jhillyerd/enmime"
@@ -30,11 +34,17 @@ type Storage interface {
 	// RemoveTmpFile removes a temporary file created by DownloadToTmpFile.
 	RemoveTmpFile(ctx context.Context, tmpFile fs.File) error
 
+	// Exists checks if an object exists in a bucket.
+	Exists(ctx context.Context, bucket, key string) (bool, error)
+
 	// Upload uploads an object to a bucket, using the contents from the reader, setting the given content type.
 	Upload(ctx context.Context, bucket, key, contentType string, r io.Reader) error
 
 	// Delete deletes an object from a bucket.
 	Delete(ctx context.Context, bucket, key string) error
+
+	// List returns the keys of the objects of a bucket starting with the given prefix.
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
 }
 
 //go:generate mockery --name=Storage --testonly --inpackage --disable-version-string --quiet
@@ -42,6 +52,16 @@ type Storage interface {
 type Mailer interface {
 	// SendReply sends a reply email.
 	SendReply(ctx context.Context, messageID, from, to, subject, text, html string) error
+
+	// SendReplyWithAttachments sends a reply email with attachments.
+	SendReplyWithAttachments(ctx context.Context, messageID, from, to, subject, text, html string, attachments []MailAttachment) error
+}
+
+// MailAttachment is a file attached to a reply email.
+type MailAttachment struct {
+	FileName    string
+	ContentType string
+	Content     []byte
 }
 
 //go:generate mockery --name=Mailer --testonly --inpackage --disable-version-string --quiet
@@ -51,11 +71,42 @@ type QRApp struct {
 	Mailer         Mailer
 	FilesBucket    string
 	FilesBucketURL string
+	// VerdictPolicy decides which emails received by SES are processed (all of them if nil).
+	VerdictPolicy *VerdictPolicy
+	// Allowlist restricts who can publish files (everybody if nil).
+	Allowlist *SenderAllowlist
+	// ReplyToUnauthorized replies with a "not authorized" message to the senders not in the Allowlist (otherwise their
+	// emails are silently dropped).
+	ReplyToUnauthorized bool
+	// KeyStrategy decides the keys of the published files (KeyStrategyName by default).
+	KeyStrategy KeyStrategy
+	// QROptions customize how the QR codes are rendered.
+	QROptions QROptions
+	// Modes are named options (as accepted by ParseOptions), selected by sub-addressing the recipient: emails sent to
+	// qr+private@mydomain.com use the options of the "private" mode. DefaultModes are used if nil.
+	Modes map[string]string
+	// Recipients are the addresses of QRApp. When set, the emails received by SES for any other address or for
+	// sub-addresses of unknown modes are dropped, so the receipt rule can accept the whole domain (and every mode).
+	Recipients []string
+	// RecordsBucket is a private bucket storing the records of what was published for every email, needed to delete
+	// the files when they expire. Nothing is recorded if empty.
+	RecordsBucket string
+	// Expiration is how long the files are published by default (forever if zero), see DeleteExpired.
+	Expiration time.Duration
+}
+
+func (q *QRApp) modes() map[string]string {
+	if q.Modes == nil {
+		return DefaultModes
+	}
+	return q.Modes
 }
 
 type Message struct {
 	NotificationType string `json:"notificationType"`
 	Mail             struct {
+		Timestamp     time.Time `json:"timestamp"`
+		MessageID     string    `json:"messageId"`
 		CommonHeaders struct {
 			ReturnPath string   `json:"returnPath"`
 			From       []string `json:"from"`
@@ -64,39 +115,214 @@ type Message struct {
 		} `json:"commonHeaders"`
 	} `json:"mail"`
 	Receipt struct {
-		Recipients []string `json:"recipients"`
-		Action     struct {
-			Type       string `json:"type"`
+		Recipients   []string `json:"recipients"`
+		SpamVerdict  Verdict  `json:"spamVerdict"`
+		VirusVerdict Verdict  `json:"virusVerdict"`
+		SPFVerdict   Verdict  `json:"spfVerdict"`
+		DKIMVerdict  Verdict  `json:"dkimVerdict"`
+		DMARCVerdict Verdict  `json:"dmarcVerdict"`
+		Action       struct {
+			Type string `json:"type"`
+			// S3 action
 			BucketName string `json:"bucketName"`
 			ObjectKey  string `json:"objectKey"`
+			// SNS action
+			Encoding string `json:"encoding"`
 		} `json:"action"`
 	} `json:"receipt"`
+	// Content is the whole email, only included by the SNS action.
+	Content string `json:"content"`
 }
 
+// ProcessEmail processes an email received by SES, notified through SNS. The email is downloaded from S3 (S3 action)
+// or taken from the notification itself (SNS action).
 func (q *QRApp) ProcessEmail(ctx context.Context, msg *Message) error {
-	// get email from S3
-	bucket := msg.Receipt.Action.BucketName
-	key := msg.Receipt.Action.ObjectKey
-	tmpFile, err := q.Storage.DownloadToTmpFile(ctx, bucket, key)
-	if err != nil {
-		return err
+	if len(msg.Receipt.Recipients) == 0 {
+		return errors.New("missing receipt.recipients from message")
+	}
+	replyFrom := msg.Receipt.Recipients[0]
+	if len(q.Recipients) > 0 {
+		replyFrom = ""
+		for _, recipient := range msg.Receipt.Recipients {
+			if accepted, ok := acceptedRecipient(q.Recipients, q.modes(), recipient); ok {
+				replyFrom = accepted
+				break
+			}
+		}
+		if replyFrom == "" {
+			log.Printf("dropping email %s: not sent to QRApp (%s)", msg.Mail.MessageID,
+				strings.Join(msg.Receipt.Recipients, ", "))
+			return nil
+		}
 	}
-	defer q.Storage.RemoveTmpFile(ctx, tmpFile)
+	// check SES verdicts before doing anything with the email
+	if reason := q.VerdictPolicy.rejectReason(msg); reason != "" {
+		return q.rejectEmail(ctx, msg, reason)
+	}
+	// get email from S3 or from the notification itself
+	var email io.Reader
+	action := msg.Receipt.Action
+	switch action.Type {
+	case "S3":
+		tmpFile, err := q.Storage.DownloadToTmpFile(ctx, action.BucketName, action.ObjectKey)
+		if err != nil {
+			return err
+		}
+		defer q.Storage.RemoveTmpFile(ctx, tmpFile)
+		email = tmpFile
+	case "SNS":
+		if msg.Content == "" {
+			return errors.New("missing content from message")
+		}
+		switch action.Encoding {
+		case "UTF8":
+			email = strings.NewReader(msg.Content)
+		case "BASE64":
+			email = base64.NewDecoder(base64.StdEncoding, strings.NewReader(msg.Content))
+		default:
+			return fmt.Errorf("unsupported SNS action encoding %q", action.Encoding)
+		}
+	default:
+		return fmt.Errorf("unsupported receipt action type %q", action.Type)
+	}
+
+	ch := msg.Mail.CommonHeaders
+	return q.ProcessRawEmail(ctx, email, &EmailMetadata{
+		ID:                   msg.Mail.MessageID,
+		Timestamp:            msg.Mail.Timestamp,
+		ReplyFrom:            replyFrom,
+		ReturnPath:           ch.ReturnPath,
+		From:                 ch.From,
+		AuthenticatedSenders: authenticatedSenders(msg),
+		MessageID:            ch.MessageID,
+		Subject:              ch.Subject,
+	})
+}
+
+// EmailMetadata is the envelope metadata of a received email, independent of how the email was received.
+type EmailMetadata struct {
+	// ID identifies the email, like the SES message ID (derived from MessageID if empty).
+	ID string
+	// Timestamp is when the email was received (taken from the Date header if zero).
+	Timestamp time.Time
+	// ReplyFrom is the address that received the email, used as the sender of the reply.
+	ReplyFrom string
+	// ReturnPath is the address of the sender, where the reply is sent.
+	ReturnPath string
+	// From are the addresses of the From header (taken from the email if empty).
+	From []string
+	// AuthenticatedSenders are the addresses of the sender authenticated by the receiver of the email (e.g. the From
+	// addresses with a PASS DMARC verdict), the only ones the commands acting on the published files work with.
+	AuthenticatedSenders []string
+	// MessageID is the Message-ID of the email, used to thread the reply (taken from the email if empty).
+	MessageID string
+	// Subject is the subject of the email, used in the reply (taken from the email if empty).
+	Subject string
+	// QROptions override the QRApp.QROptions for this email.
+	QROptions QROptions
+}
 
+// ProcessRawEmail processes a raw RFC 5322 email: it publishes the attachments and replies to the sender with the
+// results.
+func (q *QRApp) ProcessRawEmail(ctx context.Context, r io.Reader, meta *EmailMetadata) error {
+	if meta.ReplyFrom == "" {
+		return errors.New("missing reply from address")
+	}
+	if meta.ReturnPath == "" {
+		return errors.New("missing return path")
+	}
 	// extract attachments from email
-	envelope, err := enmime.ReadEnvelope(tmpFile)
+	envelope, err := enmime.ReadEnvelope(r)
 	if err != nil {
 		return fmt.Errorf("couldn't read email: %s", err)
 	}
-	// no attachments, send an email reply with an error message
+	meta, err = completeMetadata(meta, envelope)
+	if err != nil {
+		return err
+	}
+	// check the sender is authorized to publish files
+	if sender := q.Allowlist.unauthorizedSender(meta); sender != "" {
+		log.Printf("dropping email %s: sender %s isn't authorized", meta.MessageID, sender)
+		if !q.ReplyToUnauthorized {
+			return nil
+		}
+		text := "lo siento, no estás autorizado para publicar archivos."
+		html := "<p>lo siento, no estás autorizado para publicar archivos.</p>"
+		return q.Mailer.SendReply(ctx, meta.MessageID, meta.ReplyFrom, meta.ReturnPath, meta.Subject, text, html)
+	}
+	return q.processEnvelope(ctx, envelope, meta)
+}
+
+// completeMetadata returns a copy of the metadata, filling the missing fields from the email headers.
+func completeMetadata(meta *EmailMetadata, envelope *enmime.Envelope) (*EmailMetadata, error) {
+	completed := *meta
+	if len(completed.From) == 0 {
+		// an unparseable From header is left empty
+		from, _ := envelope.AddressList("From")
+		for _, addr := range from {
+			completed.From = append(completed.From, addr.String())
+		}
+	}
+	if completed.MessageID == "" {
+		completed.MessageID = envelope.GetHeader("Message-Id")
+	}
+	if completed.Subject == "" {
+		completed.Subject = envelope.GetHeader("Subject")
+	}
+	if completed.Timestamp.IsZero() {
+		date, err := mail.ParseDate(envelope.GetHeader("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		completed.Timestamp = date
+	}
+	if completed.ID == "" {
+		completed.ID = slug.Make(completed.MessageID)
+		if completed.ID == "" {
+			id, err := randomID()
+			if err != nil {
+				return nil, err
+			}
+			completed.ID = id
+		}
+	}
+	return &completed, nil
+}
+
+// processEnvelope publishes the attachments of a parsed email and replies to the sender with the results.
+func (q *QRApp) processEnvelope(ctx context.Context, envelope *enmime.Envelope, meta *EmailMetadata) error {
+	req, err := q.newRequest(meta)
+	if err != nil {
+		return err
+	}
+	name := parseCommand(envelope)
+	if cmd := commandNamed(name); cmd != nil && cmd.authenticated && len(meta.authenticatedSenders()) == 0 {
+		// anybody could spoof the sender to act on its files (an allowlist doesn't authenticate anybody either)
+		log.Printf("refusing command %s of email %s: sender %s not authenticated", name, meta.MessageID, meta.ReturnPath)
+		text := "lo siento, no pude verificar que el correo sea tuyo, así que no hice nada con tus archivos."
+		html := "<p>lo siento, no pude verificar que el correo sea tuyo, así que no hice nada con tus archivos.</p>"
+		return q.Mailer.SendReply(ctx, meta.MessageID, meta.ReplyFrom, meta.ReturnPath, meta.Subject, text, html)
+	}
+	switch name {
+	case commandDelete:
+		return q.processDelete(ctx, req, envelope)
+	case commandList:
+		return q.processList(ctx, req)
+	case commandHelp:
+		return q.processHelp(ctx, req)
+	}
 	if len(envelope.Attachments) == 0 {
+		// without attachments, generate the QR codes of the content described in the body
+		for _, handler := range bodyHandlers {
+			handled, err := handler.process(q, ctx, req, envelope)
+			if handled {
+				return err
+			}
+		}
+		// no attachments nor content in the body, send an email reply with an error message
 		text := "olvidaste los adjuntos!"
 		html := "<p>olvidaste los <b>adjuntos</b>!</p>"
-		ch := msg.Mail.CommonHeaders
-		if len(msg.Receipt.Recipients) == 0 {
-			return errors.New("missing receipt.recipients from message")
-		}
-		err := q.Mailer.SendReply(ctx, ch.MessageID, msg.Receipt.Recipients[0], ch.ReturnPath, ch.Subject, text, html)
+		err := q.Mailer.SendReply(ctx, meta.MessageID, meta.ReplyFrom, meta.ReturnPath, meta.Subject, text, html)
 		if err != nil {
 			return err
 		}
@@ -105,8 +331,14 @@ func (q *QRApp) ProcessEmail(ctx context.Context, msg *Message) error {
 	// separate images from other file types
 	imgAttachments := make([]*enmime.Part, 0, len(envelope.Attachments))
 	docAttachments := make([]*enmime.Part, 0, len(envelope.Attachments))
+	var logoAttachment *enmime.Part
 	for _, attch := range envelope.Attachments {
 		log.Printf("attachment %s %s", attch.FileName, attch.ContentType)
+		// an image named logo.png (or .jpg) is placed in the center of the QR codes of the other files
+		if logoAttachment == nil && len(envelope.Attachments) > 1 && isLogo(attch) {
+			logoAttachment = attch
+			continue
+		}
 		switch attch.ContentType {
 		case "image/jpeg", "image/png":
 			imgAttachments = append(imgAttachments, attch)
@@ -114,16 +346,47 @@ func (q *QRApp) ProcessEmail(ctx context.Context, msg *Message) error {
 			docAttachments = append(docAttachments, attch)
 		}
 	}
+	if logoAttachment != nil {
+		// the logo is checked before using it, so it doesn't fail every QR code
+		err := checkLogoSize(bytes.NewReader(logoAttachment.Content))
+		if err != nil {
+			log.Printf("ignoring logo %s: %s", logoAttachment.FileName, err)
+			req.warnings = append(req.warnings, fmt.Sprintf("no usé %s como logo: %s", logoAttachment.FileName, err))
+			logoAttachment = nil
+		}
+	}
+	if logoAttachment != nil {
+		logo, err := tmpFileName("logo*" + strings.ToLower(filepath.Ext(logoAttachment.FileName)))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(logo)
+		err = os.WriteFile(logo, logoAttachment.Content, 0600)
+		if err != nil {
+			return err
+		}
+		req.options.QR.Logo = logo
+	}
 	// analyze attachments and check if we have to use a background image
 	var attachments []*enmime.Part
 	var bkgImg string
 	switch {
+	case len(imgAttachments) == 1 && len(docAttachments) > 0 && req.options.QR.format() == "svg":
+		// a single image with additional files is a background image, not supported by SVG (but still not published)
+		attachments = docAttachments
+		req.warnings = append(req.warnings, fmt.Sprintf("no usé %s como fondo, no se puede con format=svg",
+			imgAttachments[0].FileName))
 	case len(imgAttachments) == 1 && len(docAttachments) > 0:
 		// a single image detected with additional files (use the image as background)
 		attachments = docAttachments
 		imgAttch := imgAttachments[0]
-		bkgImg = filepath.Join(os.TempDir(), fileNameSlug(imgAttch.FileName))
-		err := os.WriteFile(bkgImg, imgAttch.Content, 0666)
+		// a unique file, as the concurrent emails received by smtpd can have images with the same name
+		bkgImg, err = tmpFileName("bkg*" + strings.ToLower(filepath.Ext(imgAttch.FileName)))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(bkgImg)
+		err = os.WriteFile(bkgImg, imgAttch.Content, 0600)
 		if err != nil {
 			return err
 		}
@@ -142,88 +405,350 @@ func (q *QRApp) ProcessEmail(ctx context.Context, msg *Message) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			attachmentURL, qrImageURL, err := q.processAttachment(ctx, attachment, bkgImg)
-			results <- ProcessingResult{
-				AttachmentName: attachment.FileName,
-				AttachmentURL:  attachmentURL,
-				QRImageURL:     qrImageURL,
-				Error:          err,
-			}
+			result, err := q.processAttachment(ctx, req, attachment, bkgImg)
+			result.AttachmentName = attachment.FileName
+			result.Error = err
+			results <- result
 		}()
 	}
 	wg.Wait()
-	if err != nil {
-		return err
-	}
 	close(results)
 	// send response email
-	err = q.sendReply(ctx, results, msg)
+	err = q.sendReply(ctx, results, req)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// newRequest returns the request to process the email, getting the options from the recipient mode and the subject,
+// overriding the global and metadata ones.
+func (q *QRApp) newRequest(meta *EmailMetadata) (*request, error) {
+	req := &request{
+		meta: meta,
+		options: RequestOptions{
+			QR:          q.QROptions.Merge(meta.QROptions),
+			KeyStrategy: q.KeyStrategy,
+			Expires:     q.Expiration,
+		},
+	}
+	if mode := recipientMode(meta.ReplyFrom); mode != "" {
+		modeOpts, ok := q.modes()[mode]
+		if ok {
+			req.warnings = req.options.Parse(modeOpts)
+		} else {
+			req.warnings = append(req.warnings, fmt.Sprintf("no conozco el modo %s", mode))
+		}
+	}
+	req.warnings = append(req.warnings, req.options.Parse(meta.Subject)...)
+	if req.options.Expires > 0 && q.RecordsBucket == "" {
+		req.warnings = append(req.warnings, "no puedo borrar los archivos automáticamente, no tengo dónde anotar cuándo")
+		req.options.Expires = 0
+	}
+	err := req.options.QR.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// request is an email being processed.
+type request struct {
+	meta *EmailMetadata
+	// options are the QRApp options, overridden by the metadata, the recipient mode and the options in the subject.
+	options RequestOptions
+	// warnings about the mode and the options in the subject, included in the reply.
+	warnings []string
+}
+
 type ProcessingResult struct {
 	AttachmentName string
 	AttachmentURL  string
 	QRImageURL     string
-	Error          error
+	// AlreadyPublished is true if the same file and its QR code were published before (see KeyStrategyContentHash).
+	AlreadyPublished bool
+	// Fallback is true if the QR code generated with the requested options couldn't be read, so it was generated again
+	// without background image and with the highest error correction level.
+	Fallback bool
+	// Content is the content encoded in the QR code instead of the AttachmentURL (e.g. the contact of a vCard), when
+	// the attachment isn't published.
+	Content string
+	// Keys of the objects published in FilesBucket (the file, its QR code and page), also the reused ones, to delete
+	// them later.
+	Keys []string
+	// Size of the published file in bytes.
+	Size  int64
+	Error error
+}
+
+// qrContent returns the content encoded in the QR code of the result.
+func (r ProcessingResult) qrContent() string {
+	if r.Content != "" {
+		return r.Content
+	}
+	return r.AttachmentURL
+}
+
+// attachmentHandler processes the attachments of some content types, instead of just publishing them.
+type attachmentHandler struct {
+	contentTypes []string
+	// extension is the usual extension of the files, to describe them in the help.
+	extension string
+	process   func(q *QRApp, ctx context.Context, req *request, attachment *enmime.Part, bkgImg string) (ProcessingResult, error)
+	help      string
+}
+
+// attachmentHandlers is the registry of the content types processed by processAttachment, the rest is published.
+var attachmentHandlers = []*attachmentHandler{
+	{
+		contentTypes: []string{"text/vcard", "text/x-vcard"},
+		extension:    ".vcf",
+		process:      (*QRApp).processVCard,
+		help:         "el contacto va dentro del QR, sin publicarlo (si es muy grande, por ejemplo con foto, lo publico)",
+	},
+	{
+		contentTypes: []string{"text/calendar"},
+		extension:    ".ics",
+		process:      (*QRApp).processEvent,
+		help: "publico el evento con una página para agregarlo al calendario, y el QR apunta a esa página (con " +
+			"event=qr, el evento va dentro del QR)",
+	},
 }
 
-func (q *QRApp) processAttachment(ctx context.Context, attachment *enmime.Part, bkgImg string) (attachmentURL string, qrImgURL string, err error) {
-	// upload attachment to FilesBucket
-	attachmentKey := fileNameSlug(attachment.FileName)
-	err = q.Storage.Upload(ctx, q.FilesBucket, attachmentKey, attachment.ContentType, bytes.NewReader(attachment.Content))
+// bodyHandler processes the content described in the body of the emails without attachments.
+type bodyHandler struct {
+	name string
+	// process returns false if the body doesn't describe the content of the handler.
+	process func(q *QRApp, ctx context.Context, req *request, envelope *enmime.Envelope) (bool, error)
+	help    string
+}
+
+// bodyHandlers is the registry of the content processed in the body of the emails without attachments, in order.
+var bodyHandlers = []*bodyHandler{
+	{
+		name:    "Wi-Fi",
+		process: (*QRApp).processWiFiBody,
+		help: "una red descrita con líneas ssid: ..., password: ... (y opcionalmente security: WPA, WEP o none, y " +
+			"hidden: sí), el QR va solo en mi respuesta porque tiene la clave",
+	},
+	{
+		name:    "URLs",
+		process: (*QRApp).processURLsBody,
+		help:    "cada URL del correo (sin la firma ni el texto citado), publico solo los QR",
+	},
+}
+
+// processAttachment generates the QR code of the attachment according to its content type (see
+// attachmentHandlers).
+func (q *QRApp) processAttachment(ctx context.Context, req *request, attachment *enmime.Part, bkgImg string) (ProcessingResult, error) {
+	for _, handler := range attachmentHandlers {
+		for _, contentType := range handler.contentTypes {
+			if attachment.ContentType == contentType {
+				return handler.process(q, ctx, req, attachment, bkgImg)
+			}
+		}
+	}
+	return q.publishAttachment(ctx, req, attachment, bkgImg)
+}
+
+// publishAttachment publishes the attachment and the QR code of its URL.
+func (q *QRApp) publishAttachment(ctx context.Context, req *request, attachment *enmime.Part, bkgImg string) (result ProcessingResult, err error) {
+	attachmentKey, err := req.options.KeyStrategy.attachmentKey(req.meta, attachment)
+	if err != nil {
+		return
+	}
+	qrImgKey, err := req.options.KeyStrategy.qrImageKey(attachmentKey, attachment.FileName, bkgImg, req.options.QR)
 	if err != nil {
 		return
 	}
+	result.AttachmentURL, err = q.filesStaticWebsiteURL(attachmentKey)
+	if err != nil {
+		return
+	}
+	objects := []publishedObject{{key: attachmentKey, contentType: attachment.ContentType, content: attachment.Content}}
+	err = q.publishWithQR(ctx, req, result.AttachmentURL, attachment.FileName, qrImgKey, bkgImg, objects, &result)
+	if err != nil {
+		return
+	}
+	result.Size = int64(len(attachment.Content))
+	return
+}
+
+// publishContentQR publishes the QR code of the attachment, encoding the given content (e.g. a contact) instead of
+// the URL of the attachment, which isn't published.
+func (q *QRApp) publishContentQR(ctx context.Context, req *request, attachment *enmime.Part, content, bkgImg string) (result ProcessingResult, err error) {
+	result.Content = content
+	key, err := req.options.KeyStrategy.attachmentKey(req.meta, attachment)
+	if err != nil {
+		return
+	}
+	qrImgKey, err := req.options.KeyStrategy.qrImageKey(key, attachment.FileName, bkgImg, req.options.QR)
+	if err != nil {
+		return
+	}
+	err = q.publishWithQR(ctx, req, content, attachment.FileName, qrImgKey, bkgImg, nil, &result)
+	return
+}
+
+// publishedObject is an object published in FilesBucket along with a QR code (see publishWithQR).
+type publishedObject struct {
+	key         string
+	contentType string
+	content     []byte
+}
+
+// publishWithQR publishes the QR code of content (with the given key) and the objects in FilesBucket, setting the
+// QRImageURL, Fallback, Keys and AlreadyPublished of the result. With content addressed keys, the QR code and objects
+// already published are reused. The QR code is uploaded first, so nothing is uploaded if it can't be read, and the
+// objects created by this call are removed if the whole operation isn't successful.
+func (q *QRApp) publishWithQR(ctx context.Context, req *request, content, name, qrImgKey, bkgImg string, objects []publishedObject, result *ProcessingResult) (err error) {
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.key)
+	}
+	existing, err := q.existing(ctx, req, append(keys, qrImgKey)...)
+	if err != nil {
+		return err
+	}
+	published := existing[qrImgKey]
+	for _, key := range keys {
+		published = published && existing[key]
+	}
+	if published {
+		result.AlreadyPublished = true
+		result.Keys = append(keys, qrImgKey)
+		result.QRImageURL, err = q.filesStaticWebsiteURL(qrImgKey)
+		return err
+	}
+	err = q.publishQR(ctx, req, content, name, qrImgKey, bkgImg, result)
+	if err != nil {
+		return err
+	}
+	var created []string
+	if !existing[qrImgKey] {
+		created = append(created, qrImgKey)
+	}
 	defer func() {
-		// remove attachment from bucket if the whole operation isn't successful
 		if err != nil {
-			deleteErr := q.Storage.Delete(context.Background(), q.FilesBucket, attachmentKey)
-			if deleteErr != nil {
-				log.Printf("couldn't delete %s from %s: %s", attachmentKey, q.FilesBucket, deleteErr)
-			}
+			q.removeFiles(created...)
 		}
 	}()
-	attachmentURL, err = q.filesStaticWebsiteURL(attachmentKey)
-	if err != nil {
-		return
+	for _, object := range objects {
+		if existing[object.key] {
+			continue
+		}
+		err = q.Storage.Upload(ctx, q.FilesBucket, object.key, object.contentType, bytes.NewReader(object.content))
+		if err != nil {
+			return err
+		}
+		created = append(created, object.key)
 	}
-	// generate QR code
-	qrImgKey := attachmentKey + ".qr.png"
-	outputImg := filepath.Join(os.TempDir(), qrImgKey)
-	err = q.generateQR(attachmentURL, bkgImg, outputImg)
+	result.Keys = append(keys, result.Keys...)
+	return nil
+}
+
+// publishQR generates the QR code of content, checks it can be read and uploads it to FilesBucket, setting the
+// QRImageURL (and Fallback) of the result.
+func (q *QRApp) publishQR(ctx context.Context, req *request, content, name, key, bkgImg string, result *ProcessingResult) error {
+	outputImg, err := tmpFileName("qr*." + req.options.QR.format())
 	if err != nil {
-		return
+		return err
 	}
 	defer os.Remove(outputImg)
+	result.Fallback, err = q.generateVerifiedQR(content, name, bkgImg, outputImg, req.options.QR)
+	if err != nil {
+		return err
+	}
 	// upload QR code to FilesBucket
 	r, err := os.Open(outputImg)
 	if err != nil {
-		return
+		return err
 	}
 	defer r.Close()
-	qrImgURL, err = q.filesStaticWebsiteURL(qrImgKey)
+	result.QRImageURL, err = q.filesStaticWebsiteURL(key)
+	if err != nil {
+		return err
+	}
+	err = q.Storage.Upload(ctx, q.FilesBucket, key, req.options.QR.contentType(), r)
 	if err != nil {
+		return err
+	}
+	result.Keys = append(result.Keys, key)
+	return nil
+}
+
+// generateVerifiedQR generates the QR code of content in outputImg and checks it can be read (SVG images are rendered
+// as is, without background image nor logo), otherwise generates it again with the fallback options.
+func (q *QRApp) generateVerifiedQR(content, name, bkgImg, outputImg string, opts QROptions) (fallback bool, err error) {
+	err = q.generateQR(content, name, bkgImg, outputImg, opts)
+	if err != nil {
+		return
+	}
+	if opts.format() != "png" {
 		return
 	}
-	err = q.Storage.Upload(ctx, q.FilesBucket, qrImgKey, "image/png", r)
+	verifyErr := verifyQR(outputImg, content)
+	if verifyErr == nil {
+		return
+	}
+	log.Printf("QR code of %s not verified, using fallback options: %s", name, verifyErr)
+	fallback = true
+	err = q.generateQR(content, name, "", outputImg, opts.fallback())
+	if err != nil {
+		return
+	}
+	err = verifyQR(outputImg, content)
 	return
 }
 
-func (q *QRApp) generateQR(url, bkgImg, outputImg string) error {
-	qrCode, err := qrcode.New(url)
+// existing returns which of the given keys already exist in FilesBucket. They're only checked with content addressed
+// keys, as the objects published with the other strategies are always uploaded again.
+func (q *QRApp) existing(ctx context.Context, req *request, keys ...string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	if req.options.KeyStrategy != KeyStrategyContentHash {
+		return existing, nil
+	}
+	for _, key := range keys {
+		exists, err := q.Storage.Exists(ctx, q.FilesBucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check if %s exists: %s", key, err)
+		}
+		existing[key] = exists
+	}
+	return existing, nil
+}
+
+// removeFiles deletes the objects uploaded to FilesBucket by an operation that wasn't successful.
+func (q *QRApp) removeFiles(keys ...string) {
+	for _, key := range keys {
+		err := q.Storage.Delete(context.Background(), q.FilesBucket, key)
+		if err != nil {
+			log.Printf("couldn't delete %s from %s: %s", key, q.FilesBucket, err)
+		}
+	}
+}
+
+// generateQR generates the QR code of url in outputImg. The name of the file is used in the caption of framed QR
+// codes.
+func (q *QRApp) generateQR(url, name, bkgImg, outputImg string, opts QROptions) error {
+	qrCode, err := qrcode.NewWith(url, opts.encodeOptions()...)
 	if err != nil {
 		return err
 	}
-	options := make([]standard.ImageOption, 0, 2)
-	options = append(options, standard.WithQRWidth(21))
-	if bkgImg != "" {
-		options = append(options, standard.WithHalftone(bkgImg))
+	if side := qrCode.Dimension()*int(opts.moduleWidth()) + 2*opts.border(); opts.format() == "png" && side > maxImageSide {
+		return fmt.Errorf("the QR code would be %d pixels wide, more than %d (use a smaller size or border)", side,
+			maxImageSide)
+	}
+	var w qrcode.Writer
+	if opts.format() == "svg" {
+		w, err = newSVGWriter(outputImg, opts)
+	} else {
+		options := opts.imageOptions()
+		if bkgImg != "" {
+			options = append(options, standard.WithHalftone(bkgImg))
+		}
+		w, err = standard.New(outputImg, options...)
 	}
-	w, err := standard.New(outputImg, options...)
 	if err != nil {
 		return err
 	}
@@ -231,6 +756,10 @@ func (q *QRApp) generateQR(url, bkgImg, outputImg string) error {
 	if err != nil {
 		return err
 	}
+	// draw the logo and frame over the generated image
+	if opts.decorated() {
+		return decorateQR(outputImg, name, opts)
+	}
 	return nil
 }
 
@@ -244,24 +773,116 @@ func init() {
 {{- define "result" -}}
 	{{- if .Error -}}
 No pude generar el código QR de {{.AttachmentName}}: {{.Error}}
+	{{- else if .Content -}}
+{{.AttachmentName}} va dentro del QR, sin publicar el archivo. El QR está en {{.QRImageURL}}.
+		{{- if .Fallback}} Ojo: el QR no se podía leer, así que lo generé sin imagen de fondo y con más corrección de errores.{{end -}}
+	{{- else if .AlreadyPublished -}}
+{{.AttachmentName}} ya estaba publicado en {{.AttachmentURL}}. El QR está en {{.QRImageURL}}.
 	{{- else -}}
 {{.AttachmentName}} quedó en {{.AttachmentURL}}. El QR está en {{.QRImageURL}}.
+		{{- if .Fallback}} Ojo: el QR no se podía leer, así que lo generé sin imagen de fondo y con más corrección de errores.{{end -}}
 	{{- end -}}
 {{- end -}}
 
-{{if eq (len .) 1}}
-{{- template "result" (index . 0) -}}
+{{with .WiFi}}
+	{{- if .Error -}}
+No pude generar el código QR de la red Wi-Fi {{.SSID}}: {{.Error}}
+	{{- else -}}
+Te adjunto el código QR de la red Wi-Fi {{.SSID}}, no lo publiqué en ninguna parte.
+	{{- end -}}
+{{end}}
+{{- with .Deleted}}
+	{{- if .Error -}}
+No pude borrar lo publicado: {{.Error}}
+	{{- else if or .URLs .Kept -}}
+		{{- with .URLs -}}
+Borré todo lo publicado para ese correo:
+{{range $u := .}}* {{$u}}
+{{end}}
+		{{- end -}}
+		{{- with .Kept -}}
+No borré estos archivos porque otros correos también los publicaron:
+{{range $u := .}}* {{$u}}
+{{end}}
+		{{- end -}}
+	{{- else -}}
+No encontré nada publicado para ese correo, quizás ya lo borré.
+	{{- end -}}
+{{end}}
+{{- with .Listed}}
+	{{- if .Error -}}
+No pude revisar lo que tienes publicado: {{.Error}}
+	{{- else if .Files -}}
+Tienes publicado:
+{{range $f := .Files}}* {{$f.Name}}: {{with $f.URL}}{{.}}{{end}}{{if and $f.URL $f.QRImageURL}}, {{end}}
+{{- with $f.QRImageURL}}QR en {{.}}{{end}} (publicado el {{$f.Published}}{{with $f.Size}}, {{.}}{{end}}
+{{- with $f.Expires}}, se borra el {{.}}{{end}})
+{{end}}
+	{{- else -}}
+No tienes nada publicado.
+	{{- end -}}
+{{end}}
+{{- with .Help -}}
+Envíame archivos adjuntos y te respondo con un código QR para cada uno, publicándolos en la web, salvo estos tipos
+de archivo:
+{{range $i := .ContentTypes}}* {{$i.Usage}}: {{$i.Help}}
+{{end}}
+Sin adjuntos, genero un código QR para lo primero que encuentre en el correo:
+{{range $i := .Bodies}}* {{$i.Usage}}: {{$i.Help}}
+{{end}}
+Opciones (escríbelas en el asunto, por ejemplo: menú size=10 ecc=H):
+{{range $i := .Options}}* {{$i.Usage}}: {{$i.Help}}
+{{end}}
+{{- with .Modes}}
+Modos (escríbeme a estas direcciones para usar sus opciones):
+{{range $i := .}}* {{$i.Usage}}: {{$i.Help}}
+{{end}}
+{{- end}}
+Comandos:
+{{range $i := .Commands}}* {{$i.Usage}}: {{$i.Help}}
+{{end}}
+{{- end}}
+{{- if eq (len .Results) 1}}
+{{- template "result" (index .Results 0) -}}
 {{else}}
-{{- range $v := . -}}
+{{- range $v := .Results -}}
 * {{template "result" $v}}
 {{ end -}}
-{{end}}`))
+{{end}}
+{{- with .SheetURL}}
+
+La hoja para imprimir con todos los QR está en {{.}}.
+{{- end}}
+{{- with .SheetError}}
+
+No pude generar la hoja para imprimir: {{.}}
+{{- end}}
+{{- with .Expires}}
+
+Todo lo publicado se borrará el {{.}}.
+{{- end}}
+{{- with .RecordError}}
+
+No pude anotar lo publicado, así que no se borrará solo: {{.}}
+{{- end}}
+{{- with .Warnings}}
+
+Ojo, no entendí todas las opciones:
+{{range $w := .}}* {{$w}}
+{{end}}
+{{- end}}`))
 	htmlReplyTpl = htmltpl.Must(htmltpl.New("htmlReply").Parse(`
 {{- define "result" -}}
 	{{- if .Error -}}
 No pude generar el código QR de {{.AttachmentName}}: {{.Error}}
+	{{- else if .Content -}}
+{{.AttachmentName}} (va dentro del QR, sin publicar el archivo): <a href="{{.QRImageURL}}">código QR</a>.
+		{{- if .Fallback}} Ojo: el QR no se podía leer, así que lo generé sin imagen de fondo y con más corrección de errores.{{end -}}
+	{{- else if .AlreadyPublished -}}
+<a href="{{.AttachmentURL}}">{{.AttachmentName}}</a> (ya estaba publicado): <a href="{{.QRImageURL}}">código QR</a>.
 	{{- else -}}
 <a href="{{.AttachmentURL}}">{{.AttachmentName}}</a>: <a href="{{.QRImageURL}}">código QR</a>.
+		{{- if .Fallback}} Ojo: el QR no se podía leer, así que lo generé sin imagen de fondo y con más corrección de errores.{{end -}}
 	{{- end -}}
 {{- end -}}
 <!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
@@ -271,20 +892,143 @@ No pude generar el código QR de {{.AttachmentName}}: {{.Error}}
         <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
     </head>
     <body>
-{{if eq (len .) 1 -}}
-<p>{{- template "result" (index . 0) -}}</p>
-{{- else -}}
+{{with .WiFi -}}
+	{{- if .Error -}}
+<p>No pude generar el código QR de la red Wi-Fi <b>{{.SSID}}</b>: {{.Error}}</p>
+	{{- else -}}
+<p>Te adjunto el código QR de la red Wi-Fi <b>{{.SSID}}</b>, no lo publiqué en ninguna parte.</p>
+	{{- end -}}
+{{end}}
+{{- with .Deleted -}}
+	{{- if .Error -}}
+<p>No pude borrar lo publicado: {{.Error}}</p>
+	{{- else if or .URLs .Kept -}}
+		{{- with .URLs -}}
+<p>Borré todo lo publicado para ese correo:</p>
+<ul>
+{{range $u := . -}}
+<li>{{$u}}</li>
+{{ end -}}
+</ul>
+		{{- end -}}
+		{{- with .Kept -}}
+<p>No borré estos archivos porque otros correos también los publicaron:</p>
+<ul>
+{{range $u := . -}}
+<li>{{$u}}</li>
+{{ end -}}
+</ul>
+		{{- end -}}
+	{{- else -}}
+<p>No encontré nada publicado para ese correo, quizás ya lo borré.</p>
+	{{- end -}}
+{{end}}
+{{- with .Listed -}}
+	{{- if .Error -}}
+<p>No pude revisar lo que tienes publicado: {{.Error}}</p>
+	{{- else if .Files -}}
+<p>Tienes publicado:</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Archivo</th><th>Código QR</th><th>Publicado</th><th>Tamaño</th><th>Se borra</th></tr>
+{{range $f := .Files -}}
+<tr><td>{{if $f.URL}}<a href="{{$f.URL}}">{{$f.Name}}</a>{{else}}{{$f.Name}}{{end}}</td>
+<td>{{with $f.QRImageURL}}<a href="{{.}}">código QR</a>{{end}}</td>
+<td>{{$f.Published}}</td><td>{{$f.Size}}</td><td>{{$f.Expires}}</td></tr>
+{{ end -}}
+</table>
+	{{- else -}}
+<p>No tienes nada publicado.</p>
+	{{- end -}}
+{{end}}
+{{- with .Help -}}
+<p>Envíame archivos adjuntos y te respondo con un código QR para cada uno, publicándolos en la web, salvo estos
+tipos de archivo:</p>
+<ul>
+{{range $i := .ContentTypes -}}
+<li><b>{{$i.Usage}}</b>: {{$i.Help}}</li>
+{{ end -}}
+</ul>
+<p>Sin adjuntos, genero un código QR para lo primero que encuentre en el correo:</p>
+<ul>
+{{range $i := .Bodies -}}
+<li><b>{{$i.Usage}}</b>: {{$i.Help}}</li>
+{{ end -}}
+</ul>
+<p>Opciones (escríbelas en el asunto, por ejemplo: <i>menú size=10 ecc=H</i>):</p>
+<ul>
+{{range $i := .Options -}}
+<li><b>{{$i.Usage}}</b>: {{$i.Help}}</li>
+{{ end -}}
+</ul>
+{{- with .Modes}}
+<p>Modos (escríbeme a estas direcciones para usar sus opciones):</p>
+<ul>
+{{range $i := . -}}
+<li><b>{{$i.Usage}}</b>: {{$i.Help}}</li>
+{{ end -}}
+</ul>
+{{- end}}
+<p>Comandos:</p>
+<ul>
+{{range $i := .Commands -}}
+<li><b>{{$i.Usage}}</b>: {{$i.Help}}</li>
+{{ end -}}
+</ul>
+{{end}}
+{{- if eq (len .Results) 1 -}}
+<p>{{- template "result" (index .Results 0) -}}</p>
+{{- else if .Results -}}
 <ol>
-{{range $v := . -}}
+{{range $v := .Results -}}
 <li>{{template "result" $v}}</li>
 {{ end -}}
 </ol>
+{{- end}}
+{{- with .SheetURL}}
+<p><a href="{{.}}">Hoja para imprimir</a> con todos los QR.</p>
+{{- end}}
+{{- with .SheetError}}
+<p>No pude generar la hoja para imprimir: {{.}}</p>
+{{- end}}
+{{- with .Expires}}
+<p>Todo lo publicado se borrará el {{.}}.</p>
+{{- end}}
+{{- with .RecordError}}
+<p>No pude anotar lo publicado, así que no se borrará solo: {{.}}</p>
+{{- end}}
+{{- with .Warnings}}
+<p>Ojo, no entendí todas las opciones:</p>
+<ul>
+{{range $w := . -}}
+<li>{{$w}}</li>
+{{ end -}}
+</ul>
 {{- end}}
     </body>
 </html>`))
 }
 
-func (q *QRApp) sendReply(ctx context.Context, results <-chan ProcessingResult, msg *Message) error {
+// replyData is the data used to evaluate the reply templates.
+type replyData struct {
+	Results  []ProcessingResult
+	Warnings []string
+	// SheetURL is the URL of the printable sheet with all the QR codes (if requested).
+	SheetURL   string
+	SheetError error
+	// Expires is when the published files are deleted (if they expire).
+	Expires     string
+	RecordError error
+	// WiFi is the result of the QR code of a Wi-Fi network, sent as an attachment of the reply.
+	WiFi *WiFiResult
+	// Deleted is the result of the delete command.
+	Deleted *DeleteResult
+	// Listed is the result of the list command.
+	Listed *ListResult
+	// Help is the result of the help command.
+	Help *HelpResult
+}
+
+func (q *QRApp) sendReply(ctx context.Context, results <-chan ProcessingResult, req *request) error {
 	// collect results in a slice
 	var resultsSlice []ProcessingResult
 	for result := range results {
@@ -295,22 +1039,54 @@ func (q *QRApp) sendReply(ctx context.Context, results <-chan ProcessingResult,
 		return resultsSlice[i].AttachmentName < resultsSlice[j].AttachmentName
 	})
 	// evaluate txt/html message templates
+	data := &replyData{
+		Results:  resultsSlice,
+		Warnings: req.warnings,
+	}
+	// publish the printable sheet with all the QR codes
+	var sheetKey string
+	if req.options.Sheet.Paper != "" {
+		sheetKey, data.SheetURL, data.SheetError = q.publishSheet(ctx, req, resultsSlice)
+		if data.SheetError != nil {
+			log.Printf("couldn't publish the sheet of %s: %s", req.meta.MessageID, data.SheetError)
+		}
+	}
+	// record what was published, to delete it when it expires
+	if q.RecordsBucket != "" {
+		publication, err := q.recordPublication(ctx, req, resultsSlice, sheetKey, data.SheetURL)
+		if err != nil {
+			log.Printf("couldn't record the publication of %s: %s", req.meta.MessageID, err)
+			if req.options.Expires > 0 {
+				data.RecordError = err
+			}
+		} else if publication != nil && publication.Expires != nil {
+			data.Expires = spanishDateTime(*publication.Expires)
+		}
+	}
+	return q.reply(ctx, req, data, nil)
+}
+
+// reply evaluates the reply templates with data and sends the reply email, with the given attachments.
+func (q *QRApp) reply(ctx context.Context, req *request, data *replyData, attachments []MailAttachment) error {
 	text := &bytes.Buffer{}
-	err := txtReplyTpl.Execute(text, resultsSlice)
+	err := txtReplyTpl.Execute(text, data)
 	if err != nil {
 		return err
 	}
 	html := &bytes.Buffer{}
-	err = htmlReplyTpl.Execute(html, resultsSlice)
+	err = htmlReplyTpl.Execute(html, data)
 	if err != nil {
 		return err
 	}
 	// send email
-	ch := msg.Mail.CommonHeaders
-	if len(msg.Receipt.Recipients) == 0 {
-		return errors.New("missing receipt.recipients from message")
+	meta := req.meta
+	if len(attachments) > 0 {
+		err = q.Mailer.SendReplyWithAttachments(ctx, meta.MessageID, meta.ReplyFrom, meta.ReturnPath, meta.Subject,
+			text.String(), html.String(), attachments)
+	} else {
+		err = q.Mailer.SendReply(ctx, meta.MessageID, meta.ReplyFrom, meta.ReturnPath, meta.Subject, text.String(),
+			html.String())
 	}
-	err = q.Mailer.SendReply(ctx, ch.MessageID, msg.Receipt.Recipients[0], ch.ReturnPath, ch.Subject, text.String(), html.String())
 	if err != nil {
 		return err
 	}
@@ -327,6 +1103,26 @@ func (q *QRApp) filesStaticWebsiteURL(key string) (string, error) {
 	return keyURL.String(), nil
 }
 
+// tmpFileName reserves a new temporary file (see os.CreateTemp), returning its name.
+func tmpFileName(pattern string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// isLogo checks if the attachment is an image named logo.
+func isLogo(attachment *enmime.Part) bool {
+	name := strings.ToLower(attachment.FileName)
+	isImage := attachment.ContentType == "image/jpeg" || attachment.ContentType == "image/png"
+	return isImage && strings.TrimSuffix(name, filepath.Ext(name)) == "logo"
+}
+
 func fileNameSlug(name string) string {
 	ext := filepath.Ext(name)
 	withoutExt := name[:len(name)-len(ext)]
@@ -227,6 +227,80 @@ func TestQRApp_HandlerMultipleAttachmentsNoBkgImage(t *testing.T) {
 	mock.AssertExpectationsForObjects(t, storage, mailer)
 }
 
+func TestQRApp_HandlerSNSAction(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification (with the email as base64 content)
+	msg, err := testingMsg("snsemail-sns-action.json")
+	require.Nil(t, err)
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedSubject := msg.Mail.CommonHeaders.Subject
+	expectedEmailAddr := msg.Receipt.Recipients[0]
+
+	// mock email reply (there's nothing to download)
+	storage := &MockStorage{}
+	mailer := &MockMailer{}
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, expectedEmailAddr, expectedReturnPath, expectedSubject,
+		"olvidaste los adjuntos!", "<p>olvidaste los <b>adjuntos</b>!</p>").Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+	// unknown encodings and action types aren't supported
+	msg.Receipt.Action.Encoding = "UTF16"
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.EqualError(t, err, `unsupported SNS action encoding "UTF16"`)
+	msg.Receipt.Action.Type = "Lambda"
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.EqualError(t, err, `unsupported receipt action type "Lambda"`)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_ProcessRawEmail(t *testing.T) {
+	t.Parallel()
+
+	// mock email reply (Message-ID and Subject are taken from the email)
+	mailer := &MockMailer{}
+	mailer.On("SendReply", ctxMatcher, "<CAO+JdM0Q_tQeGNOwny+qUh=pn7AoeQ-ghHdyTn_m0+Mj0bACrw@mail.gmail.com>",
+		"qr@mydomain.com", "jorge@larix.cl", "waiting",
+		"olvidaste los adjuntos!", "<p>olvidaste los <b>adjuntos</b>!</p>").Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        &MockStorage{},
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	email, err := mfs.Open("0molt1nl4jf3t1n11u5kcu2ui9psudnrlnumuk81")
+	require.Nil(t, err)
+	defer email.Close()
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+	// the reply can't be sent without a return path
+	err = q.ProcessRawEmail(context.Background(), strings.NewReader(""), &EmailMetadata{
+		ReplyFrom: "qr@mydomain.com",
+	})
+	assert.NotNil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, mailer)
+}
+
 func Test_fileNameSlug(t *testing.T) {
 	tests := []struct {
 		name string
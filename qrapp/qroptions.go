@@ -0,0 +1,330 @@
+package qrapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yeqown/go-qrcode/v2"
+	"github.com/yeqown/go-qrcode/writer/standard"
+)
+
+// QROptions customize how the QR codes are rendered. Zero values (nil for the flags, so they can be overridden with
+// false) mean the default.
+type QROptions struct {
+	// ModuleWidth is the width in pixels of every module (block) of the QR code (21 by default).
+	ModuleWidth uint8
+	// Border is the width in pixels of the quiet zone around the QR code (40 by default).
+	Border int
+	// Foreground is the color of the modules, as #rgb or #rrggbb (black by default).
+	Foreground string
+	// Background is the color of the background, as #rgb or #rrggbb (white by default).
+	Background string
+	// ErrorCorrection is the error correction level: L, M, Q or H (Q by default).
+	ErrorCorrection string
+	// Shape is the shape of the modules: square or circle (square by default).
+	Shape string
+	// Transparent makes the background transparent.
+	Transparent *bool
+	// Format is the image format of the QR codes: png or svg (png by default). SVG images don't support halftone
+	// background images, frames nor logos.
+	Format string
+	// Frame draws a frame around the QR code, with a caption below it.
+	Frame *bool
+	// Caption is the text below the framed QR code ("Escanéame: <file name>" by default). Setting a caption adds the
+	// frame.
+	Caption string
+	// Logo is the path of a PNG or JPEG image placed in the center of the QR code. The error correction level is raised
+	// to H.
+	Logo string
+}
+
+const (
+	defaultModuleWidth = 21
+	defaultBorder      = 40
+	// maxImageSide is the maximum width (and height) in pixels of the QR code images, limiting the memory needed to
+	// render and verify them whatever the size and border options.
+	maxImageSide = 4096
+)
+
+var errorCorrectionLevels = map[string]qrcode.EncodeOption{
+	"L": qrcode.WithErrorCorrectionLevel(qrcode.ErrorCorrectionLow),
+	"M": qrcode.WithErrorCorrectionLevel(qrcode.ErrorCorrectionMedium),
+	"Q": qrcode.WithErrorCorrectionLevel(qrcode.ErrorCorrectionQuart),
+	"H": qrcode.WithErrorCorrectionLevel(qrcode.ErrorCorrectionHighest),
+}
+
+// Merge returns a copy of the options, replacing the fields set in override.
+func (o QROptions) Merge(override QROptions) QROptions {
+	if override.ModuleWidth != 0 {
+		o.ModuleWidth = override.ModuleWidth
+	}
+	if override.Border != 0 {
+		o.Border = override.Border
+	}
+	if override.Foreground != "" {
+		o.Foreground = override.Foreground
+	}
+	if override.Background != "" {
+		o.Background = override.Background
+	}
+	if override.ErrorCorrection != "" {
+		o.ErrorCorrection = override.ErrorCorrection
+	}
+	if override.Shape != "" {
+		o.Shape = override.Shape
+	}
+	if override.Transparent != nil {
+		o.Transparent = override.Transparent
+	}
+	if override.Format != "" {
+		o.Format = override.Format
+	}
+	if override.Frame != nil {
+		o.Frame = override.Frame
+	}
+	if override.Caption != "" {
+		o.Caption = override.Caption
+	}
+	if override.Logo != "" {
+		o.Logo = override.Logo
+	}
+	return o
+}
+
+// Validate checks all the options have valid values.
+func (o QROptions) Validate() error {
+	if o.Border < 0 {
+		return fmt.Errorf("invalid border %d", o.Border)
+	}
+	for _, c := range []string{o.Foreground, o.Background} {
+		if c == "" {
+			continue
+		}
+		if _, err := parseHexColor(c); err != nil {
+			return err
+		}
+	}
+	if _, ok := errorCorrectionLevels[strings.ToUpper(o.ErrorCorrection)]; o.ErrorCorrection != "" && !ok {
+		return fmt.Errorf("invalid error correction level %q (L, M, Q or H)", o.ErrorCorrection)
+	}
+	if o.Shape != "" && o.Shape != "square" && o.Shape != "circle" {
+		return fmt.Errorf("invalid shape %q (square or circle)", o.Shape)
+	}
+	if o.Format != "" && o.Format != "png" && o.Format != "svg" {
+		return fmt.Errorf("invalid format %q (png or svg)", o.Format)
+	}
+	if utf8.RuneCountInString(o.Caption) > maxCaptionLength {
+		return fmt.Errorf("caption longer than %d characters", maxCaptionLength)
+	}
+	return nil
+}
+
+// encodeOptions returns the options to encode a QR code.
+func (o QROptions) encodeOptions() []qrcode.EncodeOption {
+	if level, ok := errorCorrectionLevels[o.errorCorrection()]; ok {
+		return []qrcode.EncodeOption{level}
+	}
+	return nil
+}
+
+// imageOptions returns the options to render a QR code with the standard (image) writer. The options must be valid.
+func (o QROptions) imageOptions() []standard.ImageOption {
+	options := []standard.ImageOption{
+		standard.WithBuiltinImageEncoder(standard.PNG_FORMAT),
+		standard.WithQRWidth(o.moduleWidth()),
+		standard.WithBorderWidth(o.border()),
+	}
+	if o.Foreground != "" {
+		fg, _ := parseHexColor(o.Foreground)
+		options = append(options, standard.WithFgColor(fg))
+	}
+	if o.Background != "" {
+		bg, _ := parseHexColor(o.Background)
+		options = append(options, standard.WithBgColor(bg))
+	}
+	if o.Shape == "circle" {
+		options = append(options, standard.WithCustomShape(circleShape{}))
+	}
+	if o.transparent() {
+		options = append(options, standard.WithBgTransparent())
+	}
+	return options
+}
+
+// errorCorrection returns the error correction level, raised to H when the logo hides some modules, so the QR code
+// can still be read.
+func (o QROptions) errorCorrection() string {
+	if o.Logo != "" && o.format() == "png" {
+		return "H"
+	}
+	return strings.ToUpper(o.ErrorCorrection)
+}
+
+func (o QROptions) moduleWidth() uint8 {
+	if o.ModuleWidth == 0 {
+		return defaultModuleWidth
+	}
+	return o.ModuleWidth
+}
+
+func (o QROptions) border() int {
+	if o.Border == 0 {
+		return defaultBorder
+	}
+	return o.Border
+}
+
+// format returns the image format of the QR codes, also used as the file extension.
+func (o QROptions) format() string {
+	if o.Format == "" {
+		return "png"
+	}
+	return o.Format
+}
+
+// contentType returns the content type of the QR code images.
+func (o QROptions) contentType() string {
+	if o.format() == "svg" {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// colors returns the foreground and background colors.
+func (o QROptions) colors() (fg, bg color.RGBA) {
+	fg, bg = color.RGBA{A: 0xff}, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	if o.Foreground != "" {
+		fg, _ = parseHexColor(o.Foreground)
+	}
+	if o.Background != "" {
+		bg, _ = parseHexColor(o.Background)
+	}
+	return
+}
+
+// decorated checks if the QR code images have a logo or frame, drawn after generating the QR code.
+func (o QROptions) decorated() bool {
+	return o.format() == "png" && (o.Logo != "" || o.framed())
+}
+
+func (o QROptions) framed() bool {
+	return (o.Frame != nil && *o.Frame) || o.Caption != ""
+}
+
+func (o QROptions) transparent() bool {
+	return o.Transparent != nil && *o.Transparent
+}
+
+// newBool returns a pointer to b, to set the flags of the options.
+func newBool(b bool) *bool {
+	return &b
+}
+
+// caption returns the caption of the QR code of the file with the given name.
+func (o QROptions) caption(name string) string {
+	if o.Caption != "" {
+		return o.Caption
+	}
+	return "Escanéame: " + name
+}
+
+// fingerprint returns a short hash of everything changing how the QR code of the file with the given name is rendered,
+// including the contents of the logo and the background image, or "" if nothing but the format is customized.
+func (o QROptions) fingerprint(name, bkgImg string) (string, error) {
+	var caption, logo, bkg string
+	var err error
+	if o.decorated() {
+		if o.framed() {
+			caption = o.caption(name)
+		}
+		logo, err = fileHash(o.Logo)
+		if err != nil {
+			return "", err
+		}
+	}
+	if o.format() == "png" {
+		bkg, err = fileHash(bkgImg)
+		if err != nil {
+			return "", err
+		}
+	}
+	rendering := func(o QROptions, caption, logo, bkg string) string {
+		fg, bg := o.colors()
+		ecc := o.errorCorrection()
+		if ecc == "" {
+			ecc = "Q"
+		}
+		shape := o.Shape
+		if shape == "" {
+			shape = "square"
+		}
+		return fmt.Sprintf("%d %d %v %v %s %s %t %q %s %s", o.moduleWidth(), o.border(), fg, bg, ecc, shape,
+			o.transparent(), caption, logo, bkg)
+	}
+	current := rendering(o, caption, logo, bkg)
+	if current == rendering(QROptions{Format: o.Format}, "", "", "") {
+		return "", nil
+	}
+	sum := sha256.Sum256([]byte(current))
+	return hex.EncodeToString(sum[:4]), nil
+}
+
+// fileHash returns the SHA-256 of the contents of the file, or "" without file.
+func fileHash(file string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// parseHexColor parses a color as #rgb or #rrggbb.
+func parseHexColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 0xff}
+	var err error
+	switch len(s) {
+	case 7:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 4:
+		_, err = fmt.Sscanf(s, "#%1x%1x%1x", &c.R, &c.G, &c.B)
+		c.R *= 17
+		c.G *= 17
+		c.B *= 17
+	default:
+		err = fmt.Errorf("invalid length")
+	}
+	if err != nil {
+		return c, fmt.Errorf("invalid color %q (#rgb or #rrggbb)", s)
+	}
+	return c, nil
+}
+
+// circleShape draws circle modules, but square finder patterns (the circle ones can't be found by many readers).
+type circleShape struct{}
+
+func (circleShape) Draw(ctx *standard.DrawContext) {
+	x, y := ctx.UpperLeft()
+	w, h := ctx.Edge()
+	radius := math.Min(float64(w), float64(h)) / 2
+	ctx.DrawCircle(x+float64(w)/2, y+float64(h)/2, radius)
+	ctx.SetColor(ctx.Color())
+	ctx.Fill()
+}
+
+func (circleShape) DrawFinder(ctx *standard.DrawContext) {
+	x, y := ctx.UpperLeft()
+	w, h := ctx.Edge()
+	ctx.DrawRectangle(x, y, float64(w), float64(h))
+	ctx.SetColor(ctx.Color())
+	ctx.Fill()
+}
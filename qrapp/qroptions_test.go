@@ -0,0 +1,99 @@
+package qrapp
+
+import (
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQROptions_Merge(t *testing.T) {
+	global := QROptions{ModuleWidth: 30, Foreground: "#000", ErrorCorrection: "M"}
+	merged := global.Merge(QROptions{Foreground: "#003366", ErrorCorrection: "H", Transparent: newBool(true)})
+	assert.Equal(t, QROptions{ModuleWidth: 30, Foreground: "#003366", ErrorCorrection: "H", Transparent: newBool(true)}, merged)
+	assert.Equal(t, global, global.Merge(QROptions{}))
+	// the flags set globally can be turned off
+	framed := QROptions{Transparent: newBool(true), Frame: newBool(true)}
+	merged = framed.Merge(QROptions{Transparent: newBool(false), Frame: newBool(false)})
+	assert.False(t, merged.transparent())
+	assert.False(t, merged.framed())
+	assert.True(t, framed.Merge(QROptions{}).framed())
+}
+
+func TestQROptions_errorCorrection(t *testing.T) {
+	assert.Equal(t, "", QROptions{}.errorCorrection())
+	assert.Equal(t, "M", QROptions{ErrorCorrection: "m"}.errorCorrection())
+	// raised by the logo, except in SVG images (without logo)
+	assert.Equal(t, "H", QROptions{ErrorCorrection: "L", Logo: "logo.png"}.errorCorrection())
+	assert.Equal(t, "L", QROptions{ErrorCorrection: "L", Logo: "logo.png", Format: "svg"}.errorCorrection())
+}
+
+func TestQROptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    QROptions
+		wantErr bool
+	}{
+		{name: "defaults", opts: QROptions{}},
+		{name: "all set", opts: QROptions{ModuleWidth: 40, Border: 10, Foreground: "#003366", Background: "#fff",
+			ErrorCorrection: "h", Shape: "circle", Transparent: newBool(true)}},
+		{name: "negative border", opts: QROptions{Border: -1}, wantErr: true},
+		{name: "color without #", opts: QROptions{Foreground: "003366"}, wantErr: true},
+		{name: "color name", opts: QROptions{Background: "red"}, wantErr: true},
+		{name: "unknown ecc", opts: QROptions{ErrorCorrection: "X"}, wantErr: true},
+		{name: "unknown shape", opts: QROptions{Shape: "star"}, wantErr: true},
+		{name: "unknown format", opts: QROptions{Format: "gif"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			assert.Equalf(t, tt.wantErr, err != nil, "Validate() = %v", err)
+		})
+	}
+}
+
+func TestQRApp_generateQRTooLarge(t *testing.T) {
+	t.Parallel()
+
+	// the largest modules and border fit for short contents, but not for long ones
+	q := &QRApp{}
+	outputImg := filepath.Join(t.TempDir(), "qr.png")
+	opts := QROptions{ModuleWidth: 255, Border: 1000}
+	err := q.generateQR(strings.Repeat("a", 1024), "contact.vcf", "", outputImg, opts)
+	assert.ErrorContains(t, err, "more than 4096")
+	err = q.generateQR("a", "a.txt", "", outputImg, QROptions{ModuleWidth: 80, Border: 1000})
+	assert.Nil(t, err)
+	// SVG images aren't rendered
+	opts.Format = "svg"
+	err = q.generateQR(strings.Repeat("a", 1024), "contact.vcf", "", filepath.Join(t.TempDir(), "qr.svg"), opts)
+	assert.Nil(t, err)
+}
+
+func TestQRApp_generateQR(t *testing.T) {
+	t.Parallel()
+
+	q := &QRApp{}
+	outputImg := filepath.Join(t.TempDir(), "qr.png")
+	opts := QROptions{ModuleWidth: 10, Border: 5, Foreground: "#003366", ErrorCorrection: "H", Transparent: newBool(true)}
+	err := q.generateQR("http://qr.mydomain.com/file.pdf", "file.pdf", "", outputImg, opts)
+	require.Nil(t, err)
+
+	f, err := os.Open(outputImg)
+	require.Nil(t, err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	require.Nil(t, err)
+	// the image is square, with the quiet zone around the modules
+	bounds := img.Bounds()
+	assert.Equal(t, bounds.Dx(), bounds.Dy())
+	assert.Equal(t, 0, (bounds.Dx()-2*opts.Border)%int(opts.ModuleWidth))
+	// transparent quiet zone, the finder pattern (top left corner) uses the foreground color
+	_, _, _, a := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0), a)
+	assert.Equal(t, color.RGBA{R: 0x00, G: 0x33, B: 0x66, A: 0xff}, color.RGBAModel.Convert(img.At(opts.Border+1, opts.Border+1)))
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3Storage struct {
@@ -41,6 +42,21 @@ func (ss *S3Storage) RemoveTmpFile(ctx context.Context, tmpFile fs.File) error {
 	return errors.New("unexpected file type")
 }
 
+func (ss *S3Storage) Exists(ctx context.Context, bucket, key string) (bool, error) {
+	_, err := ss.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (ss *S3Storage) Upload(ctx context.Context, bucket, key, contentType string, r io.Reader) error {
 	_, err := ss.S3Uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(bucket),
@@ -64,3 +80,21 @@ func (ss *S3Storage) Delete(ctx context.Context, bucket, key string) error {
 	}
 	return nil
 }
+
+func (ss *S3Storage) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(ss.S3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys, nil
+}
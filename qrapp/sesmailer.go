@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/ses"
 	"github.com/aws/aws-sdk-go-v2/service/ses/types"
@@ -16,20 +17,17 @@ type SESMailer struct {
 }
 
 func (sm *SESMailer) SendReply(ctx context.Context, messageID, from, to, subject, text, html string) error {
-	mailBuilder := enmime.Builder().Subject(subject).From("QR App", from).To("", to).
-		Header("In-Reply-To", messageID).Header("References", messageID).Text([]byte(text)).HTML([]byte(html))
-	part, err := mailBuilder.Build()
-	if err != nil {
-		return fmt.Errorf("error building email: %s", err)
-	}
-	mailBytes := &bytes.Buffer{}
-	err = part.Encode(mailBytes)
+	return sm.SendReplyWithAttachments(ctx, messageID, from, to, subject, text, html, nil)
+}
+
+func (sm *SESMailer) SendReplyWithAttachments(ctx context.Context, messageID, from, to, subject, text, html string, attachments []MailAttachment) error {
+	mailBytes, err := buildReply(messageID, from, to, subject, text, html, attachments)
 	if err != nil {
-		return fmt.Errorf("error building email: %s", err)
+		return err
 	}
 	email, err := sm.SESClient.SendRawEmail(ctx, &ses.SendRawEmailInput{
 		RawMessage: &types.RawMessage{
-			Data: mailBytes.Bytes(),
+			Data: mailBytes,
 		},
 	})
 	if err != nil {
@@ -38,3 +36,40 @@ func (sm *SESMailer) SendReply(ctx context.Context, messageID, from, to, subject
 	log.Printf("response email send: %s", *email.MessageId)
 	return nil
 }
+
+// buildReply builds a MIME reply email, threaded with the original email using the In-Reply-To and References headers.
+// The reply gets its own Message-ID at the domain of the from address, so it can be threaded too.
+func buildReply(messageID, from, to, subject, text, html string, attachments []MailAttachment) ([]byte, error) {
+	replyID, err := replyMessageID(from)
+	if err != nil {
+		return nil, fmt.Errorf("error building email: %s", err)
+	}
+	mailBuilder := enmime.Builder().Subject(subject).From("QR App", from).To("", to).Header("Message-ID", replyID).
+		Header("In-Reply-To", messageID).Header("References", messageID).Text([]byte(text)).HTML([]byte(html))
+	for _, attachment := range attachments {
+		mailBuilder = mailBuilder.AddAttachment(attachment.Content, attachment.ContentType, attachment.FileName)
+	}
+	part, err := mailBuilder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("error building email: %s", err)
+	}
+	mailBytes := &bytes.Buffer{}
+	err = part.Encode(mailBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error building email: %s", err)
+	}
+	return mailBytes.Bytes(), nil
+}
+
+// replyMessageID returns a random Message-ID at the domain of the from address.
+func replyMessageID(from string) (string, error) {
+	at := strings.LastIndex(from, "@")
+	if at < 0 || at == len(from)-1 {
+		return "", fmt.Errorf("invalid from address %q", from)
+	}
+	id, err := randomID()
+	if err != nil {
+		return "", fmt.Errorf("couldn't generate Message-ID: %s", err)
+	}
+	return fmt.Sprintf("<%s@%s>", id, from[at+1:]), nil
+}
@@ -0,0 +1,180 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/yeqown/go-qrcode/v2"
+)
+
+// SheetOptions configure the printable PDF sheet with all the QR codes of an email.
+type SheetOptions struct {
+	// Paper is the paper size: a4 or letter. The sheet isn't generated if empty.
+	Paper string
+	// Columns of the grid of QR codes of every page (3 by default).
+	Columns int
+	// Rows of the grid of QR codes of every page (4 by default).
+	Rows int
+}
+
+const (
+	defaultSheetColumns = 3
+	defaultSheetRows    = 4
+	// maxSheetGrid is the maximum number of columns and rows of a sheet.
+	maxSheetGrid = 10
+	// sheetMargin is the margin of the pages, in mm.
+	sheetMargin = 10.0
+	// sheetCaptionHeight is the height of the captions with the attachment names, in mm.
+	sheetCaptionHeight = 8.0
+)
+
+var sheetPaperSizes = map[string]string{
+	"a4":     "A4",
+	"letter": "Letter",
+}
+
+func (o SheetOptions) columns() int {
+	if o.Columns == 0 {
+		return defaultSheetColumns
+	}
+	return o.Columns
+}
+
+func (o SheetOptions) rows() int {
+	if o.Rows == 0 {
+		return defaultSheetRows
+	}
+	return o.Rows
+}
+
+// writeSheet writes a PDF laying out the QR codes of the successful results in a grid, with the attachment names as
+// captions. The QR codes are drawn as vectors (without halftone background images), using the colors, shape and
+// error correction level of the QR options.
+func writeSheet(w io.Writer, results []ProcessingResult, opts SheetOptions, qrOpts QROptions) error {
+	pdf := fpdf.New("P", "mm", sheetPaperSizes[opts.Paper], "")
+	pdf.SetMargins(sheetMargin, sheetMargin, sheetMargin)
+	pdf.SetAutoPageBreak(false, sheetMargin)
+	pdf.SetTitle("códigos QR", true)
+	pdf.SetFont("Helvetica", "", 10)
+	// core fonts use cp1252, translate the (UTF-8) attachment names
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, pageHeight := pdf.GetPageSize()
+	cellWidth := (pageWidth - 2*sheetMargin) / float64(opts.columns())
+	cellHeight := (pageHeight - 2*sheetMargin) / float64(opts.rows())
+	qrSize := math.Min(cellWidth, cellHeight-sheetCaptionHeight)
+	perPage := opts.columns() * opts.rows()
+	i := 0
+	for _, result := range results {
+		if result.Error != nil {
+			continue
+		}
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+		col, row := i%perPage%opts.columns(), i%perPage/opts.columns()
+		x := sheetMargin + float64(col)*cellWidth
+		y := sheetMargin + float64(row)*cellHeight
+		err := drawQR(pdf, result.qrContent(), x+(cellWidth-qrSize)/2, y, qrSize, qrOpts)
+		if err != nil {
+			return fmt.Errorf("couldn't draw QR code of %s: %s", result.AttachmentName, err)
+		}
+		pdf.SetXY(x, y+qrSize)
+		pdf.CellFormat(cellWidth, sheetCaptionHeight, fitText(pdf, tr(result.AttachmentName), cellWidth-2), "", 0, "CT",
+			false, 0, "")
+		i++
+	}
+	if i == 0 {
+		return fmt.Errorf("no QR codes")
+	}
+	return pdf.Output(w)
+}
+
+// drawQR draws the QR code of content (including the quiet zone) in a square of the given size.
+func drawQR(pdf *fpdf.Fpdf, content string, x, y, size float64, opts QROptions) error {
+	qrCode, err := qrcode.NewWith(content, opts.encodeOptions()...)
+	if err != nil {
+		return err
+	}
+	mw := &matrixWriter{}
+	err = qrCode.Save(mw)
+	if err != nil {
+		return err
+	}
+	// keep the proportion between the modules and the quiet zone of the images
+	moduleWidth := float64(opts.moduleWidth())
+	border := float64(opts.border())
+	scale := size / (float64(mw.mat.Width())*moduleWidth + 2*border)
+	module := moduleWidth * scale
+	if !opts.transparent() && opts.Background != "" {
+		bg, _ := parseHexColor(opts.Background)
+		pdf.SetFillColor(int(bg.R), int(bg.G), int(bg.B))
+		pdf.Rect(x, y, size, size, "F")
+	}
+	fg, _ := parseHexColor("#000")
+	if opts.Foreground != "" {
+		fg, _ = parseHexColor(opts.Foreground)
+	}
+	pdf.SetFillColor(int(fg.R), int(fg.G), int(fg.B))
+	mw.mat.Iterate(qrcode.IterDirection_ROW, func(col, row int, v qrcode.QRValue) {
+		if !v.IsSet() {
+			return
+		}
+		mx := x + border*scale + float64(col)*module
+		my := y + border*scale + float64(row)*module
+		// like the images, with square finder patterns
+		if opts.Shape == "circle" && v.Type() != qrcode.QRType_FINDER {
+			pdf.Circle(mx+module/2, my+module/2, module/2, "F")
+		} else {
+			pdf.Rect(mx, my, module, module, "F")
+		}
+	})
+	return pdf.Error()
+}
+
+// fitText shortens s with an ellipsis to fit in the given width.
+func fitText(pdf *fpdf.Fpdf, s string, width float64) string {
+	if pdf.GetStringWidth(s) <= width {
+		return s
+	}
+	for len(s) > 0 && pdf.GetStringWidth(s+"...") > width {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+// matrixWriter is a qrcode.Writer keeping the matrix of the QR code.
+type matrixWriter struct {
+	mat qrcode.Matrix
+}
+
+func (mw *matrixWriter) Write(mat qrcode.Matrix) error {
+	mw.mat = mat
+	return nil
+}
+
+func (mw *matrixWriter) Close() error {
+	return nil
+}
+
+// publishSheet publishes the sheet with the QR codes of the results in FilesBucket, returning its key and URL.
+func (q *QRApp) publishSheet(ctx context.Context, req *request, results []ProcessingResult) (key, url string, err error) {
+	sheet := &bytes.Buffer{}
+	err = writeSheet(sheet, results, req.options.Sheet, req.options.QR)
+	if err != nil {
+		return
+	}
+	key, err = req.options.KeyStrategy.sheetKey(req.meta)
+	if err != nil {
+		return
+	}
+	err = q.Storage.Upload(ctx, q.FilesBucket, key, "application/pdf", sheet)
+	if err != nil {
+		return
+	}
+	url, err = q.filesStaticWebsiteURL(key)
+	return
+}
@@ -0,0 +1,108 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSheet(t *testing.T) {
+	t.Parallel()
+
+	results := []ProcessingResult{
+		{AttachmentName: "menú.pdf", AttachmentURL: "http://qr.mydomain.com/menu.pdf"},
+		{AttachmentName: "broken.pdf", Error: errors.New("upload failed")},
+		{AttachmentName: "a very long name that doesn't fit in the cell of the grid.pdf", AttachmentURL: "http://qr.mydomain.com/a-very-long-name.pdf"},
+		{AttachmentName: "wifi.png", AttachmentURL: "http://qr.mydomain.com/wifi.png"},
+	}
+	// a page for every successful result
+	sheet := &bytes.Buffer{}
+	err := writeSheet(sheet, results, SheetOptions{Paper: "letter", Columns: 1, Rows: 1}, QROptions{Shape: "circle"})
+	require.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(sheet.Bytes(), []byte("%PDF-")))
+	assert.Equal(t, 3, bytes.Count(sheet.Bytes(), []byte("/Type /Page\n")))
+	// a single page with the default grid
+	sheet.Reset()
+	err = writeSheet(sheet, results, SheetOptions{Paper: "a4"}, QROptions{Foreground: "#003366", Background: "#eee"})
+	require.Nil(t, err)
+	assert.Equal(t, 1, bytes.Count(sheet.Bytes(), []byte("/Type /Page\n")))
+	// nothing to print
+	err = writeSheet(sheet, results[1:2], SheetOptions{Paper: "a4"}, QROptions{})
+	assert.NotNil(t, err)
+}
+
+func TestQRApp_HandlerPDFMode(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification, sent to the pdf mode
+	msg, err := testingMsg("snsemail-multiple-attachments-no-bkg.json")
+	require.Nil(t, err)
+	msg.Receipt.Recipients[0] = "qr+pdf@mydomain.com"
+	msg.Mail.CommonHeaders.Subject = "qr grid=2x2"
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, msg.Receipt.Action.BucketName, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// mock attachment, qr and sheet uploading to files bucket
+	filesBucket := "qr.mydomain.com"
+	for _, key := range []string{"toos-leen.jpeg", "xp-won.jpeg"} {
+		storage.On("Upload", ctxMatcher, filesBucket, key, "image/jpeg", mock.Anything).Return(nil)
+		storage.On("Upload", ctxMatcher, filesBucket, key+".qr.png", "image/png", mock.Anything).Return(nil)
+	}
+	storage.On("Upload", ctxMatcher, filesBucket, "a-text-file", "application/octet-stream", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "a-text-file.qr.png", "image/png", mock.Anything).Return(nil)
+	isPDF := func(r *bytes.Buffer) bool {
+		return bytes.HasPrefix(r.Bytes(), []byte("%PDF-"))
+	}
+	storage.On("Upload", ctxMatcher, filesBucket, "1ah2uuq4luuhi9f965rniq1rogipj1no4fg74j01.qr.pdf", "application/pdf",
+		mock.MatchedBy(isPDF)).Return(nil)
+	// mock email reply, linking the sheet
+	mailer := &MockMailer{}
+	expectedTxt := `* a text file quedó en http://qr.mydomain.com/a-text-file. El QR está en http://qr.mydomain.com/a-text-file.qr.png.
+* toos-leen.jpeg quedó en http://qr.mydomain.com/toos-leen.jpeg. El QR está en http://qr.mydomain.com/toos-leen.jpeg.qr.png.
+* xp-won.jpeg quedó en http://qr.mydomain.com/xp-won.jpeg. El QR está en http://qr.mydomain.com/xp-won.jpeg.qr.png.
+
+
+La hoja para imprimir con todos los QR está en http://qr.mydomain.com/1ah2uuq4luuhi9f965rniq1rogipj1no4fg74j01.qr.pdf.`
+	expectedHtml := `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+<html>
+    <head>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+        <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+    </head>
+    <body>
+<ol>
+<li><a href="http://qr.mydomain.com/a-text-file">a text file</a>: <a href="http://qr.mydomain.com/a-text-file.qr.png">código QR</a>.</li>
+<li><a href="http://qr.mydomain.com/toos-leen.jpeg">toos-leen.jpeg</a>: <a href="http://qr.mydomain.com/toos-leen.jpeg.qr.png">código QR</a>.</li>
+<li><a href="http://qr.mydomain.com/xp-won.jpeg">xp-won.jpeg</a>: <a href="http://qr.mydomain.com/xp-won.jpeg.qr.png">código QR</a>.</li>
+</ol>
+<p><a href="http://qr.mydomain.com/1ah2uuq4luuhi9f965rniq1rogipj1no4fg74j01.qr.pdf">Hoja para imprimir</a> con todos los QR.</p>
+    </body>
+</html>`
+	mailer.On("SendReply", ctxMatcher, msg.Mail.CommonHeaders.MessageID, "qr+pdf@mydomain.com",
+		msg.Mail.CommonHeaders.ReturnPath, "qr grid=2x2", expectedTxt, expectedHtml).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
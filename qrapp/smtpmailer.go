@@ -0,0 +1,179 @@
+package qrapp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPSecurity is the kind of transport security used to talk to the SMTP server.
+type SMTPSecurity int
+
+const (
+	// SMTPSecurityNone sends everything in plain text (only sensible with a local relay).
+	SMTPSecurityNone SMTPSecurity = iota
+	// SMTPSecuritySTARTTLS upgrades a plain connection with STARTTLS (usually port 587).
+	SMTPSecuritySTARTTLS
+	// SMTPSecurityTLS uses implicit TLS from the start of the connection (usually port 465).
+	SMTPSecurityTLS
+)
+
+// SMTPAuth is the SASL mechanism used to authenticate with the SMTP server.
+type SMTPAuth int
+
+const (
+	SMTPAuthNone SMTPAuth = iota
+	SMTPAuthPlain
+	SMTPAuthLogin
+)
+
+// SMTPMailer is a Mailer sending replies through an ordinary SMTP server.
+type SMTPMailer struct {
+	// Addr is the host:port of the SMTP server.
+	Addr      string
+	Security  SMTPSecurity
+	Auth      SMTPAuth
+	Username  string
+	Password  string
+	TLSConfig *tls.Config
+}
+
+func (sm *SMTPMailer) SendReply(ctx context.Context, messageID, from, to, subject, text, html string) error {
+	return sm.SendReplyWithAttachments(ctx, messageID, from, to, subject, text, html, nil)
+}
+
+func (sm *SMTPMailer) SendReplyWithAttachments(ctx context.Context, messageID, from, to, subject, text, html string, attachments []MailAttachment) error {
+	mailBytes, err := buildReply(messageID, from, to, subject, text, html, attachments)
+	if err != nil {
+		return err
+	}
+	client, err := sm.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to %s: %s", sm.Addr, err)
+	}
+	defer client.Close()
+	err = client.Mail(from)
+	if err != nil {
+		return fmt.Errorf("couldn't send email with SMTP: %s", err)
+	}
+	err = client.Rcpt(to)
+	if err != nil {
+		return fmt.Errorf("couldn't send email with SMTP: %s", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("couldn't send email with SMTP: %s", err)
+	}
+	_, err = w.Write(mailBytes)
+	if err != nil {
+		return fmt.Errorf("couldn't send email with SMTP: %s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't send email with SMTP: %s", err)
+	}
+	err = client.Quit()
+	if err != nil {
+		return fmt.Errorf("couldn't send email with SMTP: %s", err)
+	}
+	log.Printf("response email send to %s through %s", to, sm.Addr)
+	return nil
+}
+
+// dial connects to the SMTP server, setting up TLS and authentication according to the mailer configuration.
+func (sm *SMTPMailer) dial(ctx context.Context) (*smtp.Client, error) {
+	host, _, err := net.SplitHostPort(sm.Addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+	if sm.TLSConfig != nil {
+		tlsConfig = sm.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+	}
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", sm.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if sm.Security == SMTPSecurityTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if sm.Security == SMTPSecuritySTARTTLS {
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	var auth smtp.Auth
+	switch sm.Auth {
+	case SMTPAuthNone:
+	case SMTPAuthPlain:
+		auth = smtp.PlainAuth("", sm.Username, sm.Password, host)
+	case SMTPAuthLogin:
+		auth = &loginAuth{username: sm.Username, password: sm.Password, host: host}
+	default:
+		client.Close()
+		return nil, fmt.Errorf("unsupported SMTP auth %d", sm.Auth)
+	}
+	if auth != nil {
+		err = client.Auth(auth)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// loginAuth implements the (non-standard but widely used) LOGIN SASL mechanism. Like smtp.PlainAuth, it refuses to
+// send the credentials over an unencrypted connection, unless the server is localhost.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
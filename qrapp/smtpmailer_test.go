@@ -0,0 +1,222 @@
+package qrapp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpSinkResult is what a smtpSink got from a client.
+type smtpSinkResult struct {
+	TLS         bool
+	Credentials []string
+	From        string
+	To          []string
+	Data        string
+	Err         error
+}
+
+// smtpSink is a minimal SMTP server accepting a single session, used to test SMTPMailer.
+type smtpSink struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+	implicit  bool
+	results   chan smtpSinkResult
+}
+
+func newSMTPSink(t *testing.T, tlsConfig *tls.Config, implicit bool) *smtpSink {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	if implicit {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	sink := &smtpSink{
+		ln:        ln,
+		tlsConfig: tlsConfig,
+		implicit:  implicit,
+		results:   make(chan smtpSinkResult, 1),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go sink.serve()
+	return sink
+}
+
+func (s *smtpSink) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		s.results <- smtpSinkResult{Err: err}
+		return
+	}
+	defer conn.Close()
+	result := smtpSinkResult{TLS: s.implicit}
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 sink ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			result.Err = err
+			break
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-sink")
+			if s.tlsConfig != nil && !result.TLS {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN LOGIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 go ahead")
+			conn = tls.Server(conn, s.tlsConfig)
+			tp = textproto.NewConn(conn)
+			result.TLS = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			switch mechanism {
+			case "PLAIN":
+				b, _ := base64.StdEncoding.DecodeString(initial)
+				result.Credentials = strings.Split(string(b), "\x00")
+			case "LOGIN":
+				for _, challenge := range []string{"Username:", "Password:"} {
+					tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+					response, _ := tp.ReadLine()
+					b, _ := base64.StdEncoding.DecodeString(response)
+					result.Credentials = append(result.Credentials, string(b))
+				}
+			}
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			result.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			result.To = append(result.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				result.Err = err
+			}
+			result.Data = string(b)
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			s.results <- result
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+	s.results <- result
+}
+
+// selfSignedTLSConfig returns server and client TLS configs sharing a self-signed certificate for 127.0.0.1.
+func selfSignedTLSConfig(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sink"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return serverConfig, &tls.Config{RootCAs: pool}
+}
+
+func TestSMTPMailer_SendReply(t *testing.T) {
+	t.Parallel()
+
+	serverTLS, clientTLS := selfSignedTLSConfig(t)
+	tests := []struct {
+		name            string
+		security        SMTPSecurity
+		auth            SMTPAuth
+		wantCredentials []string
+	}{
+		{
+			name:     "plain text without auth",
+			security: SMTPSecurityNone,
+			auth:     SMTPAuthNone,
+		},
+		{
+			name:            "STARTTLS with AUTH PLAIN",
+			security:        SMTPSecuritySTARTTLS,
+			auth:            SMTPAuthPlain,
+			wantCredentials: []string{"", "qr", "secret"},
+		},
+		{
+			name:            "implicit TLS with AUTH LOGIN",
+			security:        SMTPSecurityTLS,
+			auth:            SMTPAuthLogin,
+			wantCredentials: []string{"qr", "secret"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var sinkTLS *tls.Config
+			if tt.security != SMTPSecurityNone {
+				sinkTLS = serverTLS
+			}
+			sink := newSMTPSink(t, sinkTLS, tt.security == SMTPSecurityTLS)
+			mailer := &SMTPMailer{
+				Addr:      sink.ln.Addr().String(),
+				Security:  tt.security,
+				Auth:      tt.auth,
+				Username:  "qr",
+				Password:  "secret",
+				TLSConfig: clientTLS,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := mailer.SendReply(ctx, "<original@mail.gmail.com>", "qr@mydomain.com", "someone@gmail.com",
+				"QR please", "some text", "<p>some html</p>")
+			require.Nil(t, err)
+
+			result := <-sink.results
+			require.Nil(t, result.Err)
+			assert.Equal(t, tt.security != SMTPSecurityNone, result.TLS)
+			assert.Equal(t, tt.wantCredentials, result.Credentials)
+			assert.Equal(t, "qr@mydomain.com", result.From)
+			assert.Equal(t, []string{"someone@gmail.com"}, result.To)
+			envelope, err := enmime.ReadEnvelope(bufio.NewReader(strings.NewReader(result.Data)))
+			require.Nil(t, err)
+			assert.Regexp(t, `^<[^@<>]+@mydomain\.com>$`, envelope.GetHeader("Message-ID"))
+			assert.Equal(t, "<original@mail.gmail.com>", envelope.GetHeader("In-Reply-To"))
+			assert.Equal(t, "<original@mail.gmail.com>", envelope.GetHeader("References"))
+			assert.Equal(t, "QR please", envelope.GetHeader("Subject"))
+			assert.Equal(t, "some text", envelope.Text)
+			assert.Equal(t, "<p>some html</p>", envelope.HTML)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// SMTPBackend is a go-smtp Backend accepting emails for the configured recipients and feeding them to QRApp, so
+// QRApp can receive emails directly (e.g. as the MX of a domain), without SES.
+type SMTPBackend struct {
+	App *QRApp
+	// Recipients are the addresses accepted by the server, any other recipient is rejected. Sub-addresses of the
+	// recipients (qr+<mode>@domain) are accepted too, only for the modes of App.
+	Recipients []string
+	// ProcessTimeout limits the time spent processing every email (no limit if zero).
+	ProcessTimeout time.Duration
+}
+
+func (sb *SMTPBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &smtpSession{backend: sb}, nil
+}
+
+// acceptedRecipient returns the configured recipient matching addr, keeping the mode of sub-addressed recipients.
+func (sb *SMTPBackend) acceptedRecipient(addr string) (string, bool) {
+	modes := DefaultModes
+	if sb.App != nil {
+		modes = sb.App.modes()
+	}
+	return acceptedRecipient(sb.Recipients, modes, addr)
+}
+
+type smtpSession struct {
+	backend    *SMTPBackend
+	returnPath string
+	recipient  string
+}
+
+func (ss *smtpSession) Reset() {
+	ss.returnPath = ""
+	ss.recipient = ""
+}
+
+func (ss *smtpSession) Logout() error {
+	return nil
+}
+
+func (ss *smtpSession) AuthPlain(username, password string) error {
+	return smtp.ErrAuthUnsupported
+}
+
+func (ss *smtpSession) Mail(from string, opts *smtp.MailOptions) error {
+	ss.returnPath = from
+	return nil
+}
+
+func (ss *smtpSession) Rcpt(to string) error {
+	recipient, ok := ss.backend.acceptedRecipient(to)
+	if !ok {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "No such user here",
+		}
+	}
+	ss.recipient = recipient
+	return nil
+}
+
+func (ss *smtpSession) Data(r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// the email is accepted at this point, processing errors are only logged (same as with SES)
+	if ss.returnPath == "" {
+		log.Printf("discarding email without return path (bounce?) sent to %s", ss.recipient)
+		return nil
+	}
+	log.Printf("processing email from:%s to:%s", ss.returnPath, ss.recipient)
+	ctx := context.Background()
+	if ss.backend.ProcessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ss.backend.ProcessTimeout)
+		defer cancel()
+	}
+	err = ss.backend.App.ProcessRawEmail(ctx, bytes.NewReader(raw), &EmailMetadata{
+		ReplyFrom:  ss.recipient,
+		ReturnPath: ss.returnPath,
+	})
+	if err != nil {
+		log.Printf("error processing email: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package qrapp
+
+import (
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// startSMTPServer runs a SMTP server for the backend, returning its address.
+func startSMTPServer(t *testing.T, backend *SMTPBackend) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	server := gosmtp.NewServer(backend)
+	server.Domain = "localhost"
+	server.AuthDisabled = true
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+func TestSMTPBackend(t *testing.T) {
+	t.Parallel()
+
+	// mock attachment and qr uploading to files bucket
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf.qr.png", "image/png", mock.Anything).Return(nil)
+	// mock email reply
+	mailer := &MockMailer{}
+	expectedTxt := "historia-social-el-circo.pdf quedó en http://qr.mydomain.com/historia-social-el-circo.pdf. El QR está en http://qr.mydomain.com/historia-social-el-circo.pdf.qr.png."
+	mailer.On("SendReply", ctxMatcher, "<CAO+JdM03mZS7HUBAErY91ufKz7keKZqhjSfMeycWXTYcO26aOA@mail.gmail.com>",
+		"qr@mydomain.com", "jorge@larix.cl", "código qr", expectedTxt, mock.Anything).Return(nil)
+
+	// SUT
+	addr := startSMTPServer(t, &SMTPBackend{
+		App: &QRApp{
+			Storage:        storage,
+			Mailer:         mailer,
+			FilesBucket:    filesBucket,
+			FilesBucketURL: "http://qr.mydomain.com",
+		},
+		Recipients: []string{"qr@mydomain.com"},
+	})
+	// test
+	email, err := os.ReadFile(filepath.Join("testdata", "87vgi822k1hni48use2qjakorsv84s8m9ug34301"))
+	require.Nil(t, err)
+	err = smtp.SendMail(addr, nil, "jorge@larix.cl", []string{"QR@mydomain.com"}, email)
+	assert.Nil(t, err)
+	// unknown recipients are rejected
+	err = smtp.SendMail(addr, nil, "jorge@larix.cl", []string{"nobody@mydomain.com"}, email)
+	assert.NotNil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestSMTPBackend_acceptedRecipient(t *testing.T) {
+	sb := &SMTPBackend{Recipients: []string{"qr@mydomain.com"}}
+	tests := []struct {
+		addr      string
+		want      string
+		wantFound bool
+	}{
+		{addr: "qr@mydomain.com", want: "qr@mydomain.com", wantFound: true},
+		{addr: "QR@MyDomain.com", want: "qr@mydomain.com", wantFound: true},
+		{addr: "qr+SVG@mydomain.com", want: "qr+svg@mydomain.com", wantFound: true},
+		{addr: "qr+private@otherdomain.com"},
+		{addr: "nobody@mydomain.com"},
+		// only the known modes
+		{addr: "qr+wat@mydomain.com"},
+	}
+	for _, tt := range tests {
+		got, found := sb.acceptedRecipient(tt.addr)
+		assert.Equal(t, tt.want, got, tt.addr)
+		assert.Equal(t, tt.wantFound, found, tt.addr)
+	}
+}
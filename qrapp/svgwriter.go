@@ -0,0 +1,79 @@
+package qrapp
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+
+	"github.com/yeqown/go-qrcode/v2"
+)
+
+// svgWriter is a qrcode.Writer rendering QR codes as SVG (vector) images, with the same colors, module width and
+// quiet zone as the PNG images, so they can be printed at any size.
+type svgWriter struct {
+	w    io.WriteCloser
+	opts QROptions
+}
+
+var _ qrcode.Writer = (*svgWriter)(nil)
+
+// newSVGWriter creates a svgWriter writing to the given file. The options must be valid.
+func newSVGWriter(filename string, opts QROptions) (*svgWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &svgWriter{w: f, opts: opts}, nil
+}
+
+func (sw *svgWriter) Write(mat qrcode.Matrix) error {
+	moduleWidth := int(sw.opts.moduleWidth())
+	border := sw.opts.border()
+	width := mat.Width()*moduleWidth + 2*border
+	height := mat.Height()*moduleWidth + 2*border
+	fg, bg := sw.opts.colors()
+
+	w := bufio.NewWriter(sw.w)
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height)
+	if !sw.opts.transparent() {
+		fmt.Fprintf(w, `<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, svgColor(bg))
+	}
+	// a single path with a square for every module. With circle modules, the finder patterns are still drawn with
+	// squares (so readers can find the QR code) and a circle is drawn for every other module
+	circles := sw.opts.Shape == "circle"
+	fmt.Fprintf(w, `<path fill="%s" shape-rendering="crispEdges" d="`, svgColor(fg))
+	mat.Iterate(qrcode.IterDirection_ROW, func(x, y int, v qrcode.QRValue) {
+		if !v.IsSet() || circles && v.Type() != qrcode.QRType_FINDER {
+			return
+		}
+		fmt.Fprintf(w, "M%d %dh%dv%dh-%dz", border+x*moduleWidth, border+y*moduleWidth, moduleWidth, moduleWidth,
+			moduleWidth)
+	})
+	fmt.Fprintln(w, `"/>`)
+	if circles {
+		fmt.Fprintf(w, `<g fill="%s">`+"\n", svgColor(fg))
+		radius := float64(moduleWidth) / 2
+		mat.Iterate(qrcode.IterDirection_ROW, func(x, y int, v qrcode.QRValue) {
+			if !v.IsSet() || v.Type() == qrcode.QRType_FINDER {
+				return
+			}
+			fmt.Fprintf(w, `<circle cx="%g" cy="%g" r="%g"/>`+"\n",
+				float64(border+x*moduleWidth)+radius, float64(border+y*moduleWidth)+radius, radius)
+		})
+		fmt.Fprintln(w, `</g>`)
+	}
+	fmt.Fprintln(w, `</svg>`)
+	return w.Flush()
+}
+
+func (sw *svgWriter) Close() error {
+	return sw.w.Close()
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,124 @@
+package qrapp
+
+import (
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type svgImage struct {
+	Width   int    `xml:"width,attr"`
+	Height  int    `xml:"height,attr"`
+	ViewBox string `xml:"viewBox,attr"`
+	Rect    *struct {
+		Fill string `xml:"fill,attr"`
+	} `xml:"rect"`
+	Path *struct {
+		Fill string `xml:"fill,attr"`
+		D    string `xml:"d,attr"`
+	} `xml:"path"`
+	Group *struct {
+		Fill    string     `xml:"fill,attr"`
+		Circles []struct{} `xml:"circle"`
+	} `xml:"g"`
+}
+
+func TestQRApp_generateQRSVG(t *testing.T) {
+	t.Parallel()
+
+	q := &QRApp{}
+	tests := []struct {
+		name string
+		opts QROptions
+	}{
+		{name: "squares", opts: QROptions{Format: "svg", ModuleWidth: 10, Border: 5, Foreground: "#036", Background: "#eeeeee"}},
+		{name: "transparent circles", opts: QROptions{Format: "svg", Shape: "circle", Transparent: newBool(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputImg := filepath.Join(t.TempDir(), "qr.svg")
+			err := q.generateQR("http://qr.mydomain.com/file.pdf", "file.pdf", "", outputImg, tt.opts)
+			require.Nil(t, err)
+
+			b, err := os.ReadFile(outputImg)
+			require.Nil(t, err)
+			var img svgImage
+			err = xml.Unmarshal(b, &img)
+			require.Nil(t, err)
+			// the image is square, with the quiet zone around the modules
+			assert.Equal(t, img.Width, img.Height)
+			assert.Equal(t, 0, (img.Width-2*tt.opts.border())%int(tt.opts.moduleWidth()))
+			if tt.opts.transparent() {
+				assert.Nil(t, img.Rect)
+			} else {
+				require.NotNil(t, img.Rect)
+				assert.Equal(t, "#eeeeee", img.Rect.Fill)
+			}
+			if tt.opts.Shape == "circle" {
+				require.NotNil(t, img.Group)
+				assert.Equal(t, "#000000", img.Group.Fill)
+				assert.NotEmpty(t, img.Group.Circles)
+				// with square finder patterns
+				require.NotNil(t, img.Path)
+				assert.True(t, strings.HasPrefix(img.Path.D, "M40 40h21v21h-21z"), img.Path.D[:20])
+			} else {
+				require.NotNil(t, img.Path)
+				assert.Equal(t, "#003366", img.Path.Fill)
+				// the finder pattern (top left corner) starts after the quiet zone
+				assert.True(t, strings.HasPrefix(img.Path.D, "M5 5h10v10h-10z"), img.Path.D[:20])
+			}
+		})
+	}
+}
+
+func TestQRApp_HandlerSVGMode(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification, sent to the svg mode
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	msg.Receipt.Recipients[0] = "qr+svg@mydomain.com"
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, msg.Receipt.Action.BucketName, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// mock attachment and svg qr uploading to files bucket (the background image isn't supported by svg, nor published)
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf", "application/pdf", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "historia-social-el-circo.pdf.qr.svg", "image/svg+xml", mock.Anything).Return(nil)
+	// mock email reply, reporting the unused background
+	mailer := &MockMailer{}
+	expectedTxt := `historia-social-el-circo.pdf quedó en http://qr.mydomain.com/historia-social-el-circo.pdf. El QR está en http://qr.mydomain.com/historia-social-el-circo.pdf.qr.svg.
+
+Ojo, no entendí todas las opciones:
+* no usé 328-3286785_png-file-circus-icon-png.jpeg como fondo, no se puede con format=svg
+`
+	mailer.On("SendReply", ctxMatcher, msg.Mail.CommonHeaders.MessageID, "qr+svg@mydomain.com",
+		msg.Mail.CommonHeaders.ReturnPath, msg.Mail.CommonHeaders.Subject, expectedTxt, mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
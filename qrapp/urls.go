@@ -0,0 +1,189 @@
+package qrapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gosimple/slug"
+	"github.com/jhillyerd/enmime"
+	"golang.org/x/net/html"
+)
+
+// maxURLs is the maximum number of URLs of an email to generate QR codes for.
+const maxURLs = 20
+
+// urlRegexp matches the http(s) URLs written in the text, also the ones between angle brackets (e.g. "el menú
+// <https://example.com/menu>"), capturing the bracket.
+var urlRegexp = regexp.MustCompile(`(?:^|[^<\w])(<?)(https?://[^\s<>"']+)`)
+
+// htmlSkippedClasses are the classes used by the email clients to mark the quoted text and the signatures in the HTML
+// body.
+var htmlSkippedClasses = map[string]bool{
+	"gmail_quote":     true,
+	"gmail_signature": true,
+	"moz-cite-prefix": true,
+	"moz-signature":   true,
+}
+
+// extractURLs returns the http(s) URLs written in the text body of the email and the links of its HTML body, without
+// duplicates. The signature (after the "-- " line) and the quoted lines of previous emails are ignored. The URLs
+// between angle brackets in the text body are the links of the HTML body written by the email clients, so with an HTML
+// body they're taken from it, where the signatures and the quoted text are marked.
+func extractURLs(envelope *enmime.Envelope) []string {
+	var urls []string
+	for _, line := range bodyLines(envelope.Text) {
+		for _, match := range urlRegexp.FindAllStringSubmatch(line, -1) {
+			if match[1] == "<" && envelope.HTML != "" {
+				continue
+			}
+			urls = appendURL(urls, strings.TrimRight(match[2], ".,;:!?)]}"))
+		}
+	}
+	for _, link := range htmlLinks(envelope.HTML) {
+		urls = appendURL(urls, link)
+	}
+	if len(urls) > maxURLs {
+		urls = urls[:maxURLs]
+	}
+	return urls
+}
+
+// bodyLines returns the lines of the text body of an email written by the sender: without the signature (after the
+// "-- " line) and the quoted lines of previous emails.
+func bodyLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "-- " || line == "--" {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// htmlLinks returns the http(s) links of the HTML body, ignoring the quoted text (blockquote elements) and the
+// signatures.
+func htmlLinks(body string) []string {
+	if body == "" {
+		return nil
+	}
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var links []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.Data == "blockquote" {
+				return
+			}
+			for _, attr := range n.Attr {
+				if attr.Key == "class" {
+					for _, class := range strings.Fields(attr.Val) {
+						if htmlSkippedClasses[class] {
+							return
+						}
+					}
+				}
+				if n.Data == "a" && attr.Key == "href" {
+					href := strings.TrimSpace(attr.Val)
+					lower := strings.ToLower(href)
+					if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+						links = append(links, href)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+// appendURL appends u to urls if it's a valid URL not in urls yet.
+func appendURL(urls []string, u string) []string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return urls
+	}
+	for _, existing := range urls {
+		if existing == u {
+			return urls
+		}
+	}
+	return append(urls, u)
+}
+
+// urlSlug returns a name for the QR code of the URL, from its host and path: example-com-menu. URLs with a query or a
+// fragment get a short hash of the whole URL too, so they don't share the name: example-com-watch-1a2b3c4d
+func urlSlug(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return slug.Make(u)
+	}
+	name := slug.Make(parsed.Host + " " + parsed.Path)
+	if parsed.RawQuery == "" && parsed.Fragment == "" {
+		return name
+	}
+	hash := sha256.Sum256([]byte(u))
+	return name + "-" + hex.EncodeToString(hash[:4])
+}
+
+// processURLsBody generates the QR codes of the URLs in the body of the email, returning false if there's none.
+func (q *QRApp) processURLsBody(ctx context.Context, req *request, envelope *enmime.Envelope) (bool, error) {
+	urls := extractURLs(envelope)
+	if len(urls) == 0 {
+		return false, nil
+	}
+	return true, q.processURLs(ctx, req, urls)
+}
+
+// processURLs generates the QR codes of the URLs and replies to the sender with the results. The URLs are encoded as
+// is, nothing but the QR codes is published.
+func (q *QRApp) processURLs(ctx context.Context, req *request, urls []string) error {
+	results := make(chan ProcessingResult, len(urls))
+	wg := &sync.WaitGroup{}
+	ctx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
+	for _, u := range urls {
+		u := u
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := q.processURL(ctx, req, u)
+			result.AttachmentName = u
+			result.AttachmentURL = u
+			result.Error = err
+			results <- result
+		}()
+	}
+	wg.Wait()
+	close(results)
+	// send response email
+	return q.sendReply(ctx, results, req)
+}
+
+func (q *QRApp) processURL(ctx context.Context, req *request, u string) (result ProcessingResult, err error) {
+	key, err := req.options.KeyStrategy.urlKey(req.meta, u)
+	if err != nil {
+		return
+	}
+	qrImgKey, err := req.options.KeyStrategy.qrImageKey(key, u, "", req.options.QR)
+	if err != nil {
+		return
+	}
+	err = q.publishWithQR(ctx, req, u, u, qrImgKey, "", nil, &result)
+	return
+}
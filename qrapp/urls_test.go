@@ -0,0 +1,113 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_extractURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		html string
+		want []string
+	}{
+		{name: "no URLs", text: "hola\r\nsin links"},
+		{
+			name: "URLs",
+			text: "el menú: https://example.com/menu.\r\n(y la carta http://example.com/carta?dia=1), https://example.com/menu otra vez\r\n",
+			want: []string{"https://example.com/menu", "http://example.com/carta?dia=1"},
+		},
+		{
+			name: "links of the HTML body, signature and quoted lines",
+			text: "https://example.com/menu\r\nel mapa <https://example.com/mapa>\r\n> https://example.com/old\r\n-- \r\nhttps://example.com/signature\r\n",
+			want: []string{"https://example.com/menu", "https://example.com/mapa"},
+		},
+		{
+			name: "HTML body",
+			text: "el menú https://example.com/menu\r\nla carta <https://example.com/carta?dia=1&hora=2>\r\nLarix <https://www.larix.cl>\r\n",
+			html: `<div>el <a href="https://example.com/menu">menú</a> y <a href="https://example.com/carta?dia=1&amp;hora=2">la carta</a>` +
+				`<a href="mailto:jorge@larix.cl">Jorge</a></div><div class="gmail_signature"><a href="https://www.larix.cl">Larix</a></div>` +
+				`<blockquote><a href="https://example.com/old">antes</a></blockquote>`,
+			want: []string{"https://example.com/menu", "https://example.com/carta?dia=1&hora=2"},
+		},
+		{name: "not an URL", text: "http:// nada"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, extractURLs(&enmime.Envelope{Text: tt.text, HTML: tt.html}))
+		})
+	}
+}
+
+func Test_bodyLines(t *testing.T) {
+	text := "hola\r\n\r\n> lo anterior\r\nchao\r\n--\r\nJorge\r\n"
+	assert.Equal(t, []string{"hola", "", "chao"}, bodyLines(text))
+	assert.Equal(t, []string{""}, bodyLines(""))
+}
+
+func Test_urlSlug(t *testing.T) {
+	assert.Equal(t, "example-com", urlSlug("https://example.com"))
+	assert.Equal(t, "example-com-menu-del-dia", urlSlug("https://example.com/menú/del-día"))
+	// the query and the fragment make a different name
+	names := map[string]bool{}
+	for _, u := range []string{"https://example.com/watch", "https://example.com/watch?v=AAA", "https://example.com/watch?v=BBB",
+		"https://example.com/watch#t=1"} {
+		name := urlSlug(u)
+		assert.Regexp(t, "^example-com-watch(-[0-9a-f]{8})?$", name)
+		names[name] = true
+	}
+	assert.Len(t, names, 4)
+}
+
+func TestQRApp_ProcessRawEmailWithURLs(t *testing.T) {
+	t.Parallel()
+
+	// email without attachments, with URLs in the body
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("links").
+		Header("Message-Id", "<links@larix.cl>").
+		Text([]byte("el menú https://example.com/menu\r\ny el mapa https://example.com/mapa\r\n")).
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// mock qr uploading to files bucket, the URLs aren't published
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "example-com-menu.qr.png", "image/png", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "example-com-mapa.qr.png", "image/png", mock.Anything).Return(nil)
+	// mock email reply
+	mailer := &MockMailer{}
+	expectedTxt := `* https://example.com/mapa quedó en https://example.com/mapa. El QR está en http://qr.mydomain.com/example-com-mapa.qr.png.
+* https://example.com/menu quedó en https://example.com/menu. El QR está en http://qr.mydomain.com/example-com-menu.qr.png.
+`
+	mailer.On("SendReply", ctxMatcher, "<links@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "links", expectedTxt,
+		mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
@@ -0,0 +1,34 @@
+package qrapp
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/yeqown/go-qrcode/v2"
+)
+
+// maxVCardLength is the maximum length (in bytes) of the vCards encoded in the QR codes. Longer vCards (e.g. with a
+// photo) would need QR codes too dense to be read, so they're published like any other file.
+const maxVCardLength = 1024
+
+// processVCard generates a QR code with the contents of the vCard, so the contact can be added by scanning it, without
+// publishing the file. If the vCard doesn't fit in a QR code, it's published like any other file.
+func (q *QRApp) processVCard(ctx context.Context, req *request, attachment *enmime.Part, bkgImg string) (ProcessingResult, error) {
+	content := strings.TrimSpace(string(attachment.Content))
+	if !fitsQR(content, maxVCardLength, req.options.QR) {
+		log.Printf("vCard %s too large for a QR code (%d bytes), publishing it", attachment.FileName, len(content))
+		return q.publishAttachment(ctx, req, attachment, bkgImg)
+	}
+	return q.publishContentQR(ctx, req, attachment, content, bkgImg)
+}
+
+// fitsQR checks if content (up to maxLength bytes) can be encoded in a QR code with the options.
+func fitsQR(content string, maxLength int, opts QROptions) bool {
+	if len(content) > maxLength {
+		return false
+	}
+	_, err := qrcode.NewWith(content, opts.encodeOptions()...)
+	return err == nil
+}
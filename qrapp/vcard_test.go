@@ -0,0 +1,76 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testVCard = "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Jorge Riquelme\r\nTEL;TYPE=CELL:+56 9 1234 5678\r\nEMAIL:jorge@larix.cl\r\nEND:VCARD"
+
+func Test_fitsQR(t *testing.T) {
+	assert.True(t, fitsQR(testVCard, maxVCardLength, QROptions{}))
+	assert.False(t, fitsQR(testVCard, 10, QROptions{}))
+	assert.False(t, fitsQR(strings.Repeat("x", 3000), 4000, QROptions{}))
+}
+
+func TestQRApp_ProcessRawEmailWithVCard(t *testing.T) {
+	t.Parallel()
+
+	// email with a small vCard and a vCard with a photo, too large for a QR code
+	largeVCard := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Larix\r\nPHOTO;ENCODING=b;TYPE=JPEG:" + strings.Repeat("QUJD", 500) +
+		"\r\nEND:VCARD\r\n"
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("contactos").
+		Header("Message-Id", "<contactos@larix.cl>").
+		Text([]byte("mis contactos")).
+		AddAttachment([]byte(testVCard+"\r\n"), "text/vcard", "jorge.vcf").
+		AddAttachment([]byte(largeVCard), "text/x-vcard", "larix.vcf").
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// the small vCard goes in the QR code, the large one is published
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	withVCard := func(f *os.File) bool {
+		return verifyQR(f.Name(), testVCard) == nil
+	}
+	storage.On("Upload", ctxMatcher, filesBucket, "jorge.vcf.qr.png", "image/png", mock.MatchedBy(withVCard)).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "larix.vcf", "text/x-vcard", mock.Anything).Return(nil)
+	storage.On("Upload", ctxMatcher, filesBucket, "larix.vcf.qr.png", "image/png", mock.Anything).Return(nil)
+	// mock email reply
+	mailer := &MockMailer{}
+	expectedTxt := `* jorge.vcf va dentro del QR, sin publicar el archivo. El QR está en http://qr.mydomain.com/jorge.vcf.qr.png.
+* larix.vcf quedó en http://qr.mydomain.com/larix.vcf. El QR está en http://qr.mydomain.com/larix.vcf.qr.png.
+`
+	mailer.On("SendReply", ctxMatcher, "<contactos@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "contactos",
+		expectedTxt, mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
@@ -0,0 +1,78 @@
+package qrapp
+
+import (
+	"context"
+	"log"
+)
+
+// Verdict is the result of one of the checks done by SES on a received email.
+type Verdict struct {
+	// Status is PASS, FAIL, GRAY, PROCESSING_FAILED or DISABLED.
+	Status string `json:"status"`
+}
+
+const (
+	VerdictPass = "PASS"
+	VerdictFail = "FAIL"
+)
+
+// VerdictPolicy decides which emails are processed according to the SES verdicts, so nobody can publish files just by
+// spoofing a sender.
+type VerdictPolicy struct {
+	// RejectSpam rejects emails with a FAIL spam verdict.
+	RejectSpam bool
+	// RejectVirus rejects emails with a FAIL virus verdict.
+	RejectVirus bool
+	// RequireSPFOrDKIM rejects emails without a PASS verdict from SPF or DKIM.
+	RequireSPFOrDKIM bool
+	// RequireDMARC rejects emails without a PASS DMARC verdict.
+	RequireDMARC bool
+	// ReplyOnReject sends a polite reply to the rejected emails (otherwise they are silently dropped).
+	ReplyOnReject bool
+}
+
+// rejectReason returns why the email must be rejected, or an empty string if it can be processed.
+func (vp *VerdictPolicy) rejectReason(msg *Message) string {
+	if vp == nil {
+		return ""
+	}
+	r := msg.Receipt
+	switch {
+	case vp.RejectVirus && r.VirusVerdict.Status == VerdictFail:
+		return "virus verdict FAIL"
+	case vp.RejectSpam && r.SpamVerdict.Status == VerdictFail:
+		return "spam verdict FAIL"
+	case vp.RequireSPFOrDKIM && r.SPFVerdict.Status != VerdictPass && r.DKIMVerdict.Status != VerdictPass:
+		return "SPF verdict " + r.SPFVerdict.Status + " and DKIM verdict " + r.DKIMVerdict.Status
+	case vp.RequireDMARC && r.DMARCVerdict.Status != VerdictPass:
+		return "DMARC verdict " + r.DMARCVerdict.Status
+	}
+	return ""
+}
+
+// authenticatedSenders returns the addresses of the sender of the email authenticated by SES: the From addresses with
+// a PASS DMARC verdict and the return path with a PASS SPF verdict. A PASS DKIM verdict only vouches for the signing
+// domain, not for any address.
+func authenticatedSenders(msg *Message) []string {
+	var senders []string
+	ch := msg.Mail.CommonHeaders
+	if msg.Receipt.DMARCVerdict.Status == VerdictPass {
+		senders = append(senders, ch.From...)
+	}
+	if msg.Receipt.SPFVerdict.Status == VerdictPass && ch.ReturnPath != "" {
+		senders = append(senders, ch.ReturnPath)
+	}
+	return senders
+}
+
+// rejectEmail logs the rejection of an email and replies to the sender if the policy says so.
+func (q *QRApp) rejectEmail(ctx context.Context, msg *Message, reason string) error {
+	ch := msg.Mail.CommonHeaders
+	log.Printf("rejecting email %s from %s: %s", ch.MessageID, ch.ReturnPath, reason)
+	if !q.VerdictPolicy.ReplyOnReject {
+		return nil
+	}
+	text := "lo siento, no pude verificar que tu correo sea legítimo, así que no lo procesé."
+	html := "<p>lo siento, no pude verificar que tu correo sea legítimo, así que no lo procesé.</p>"
+	return q.Mailer.SendReply(ctx, ch.MessageID, msg.Receipt.Recipients[0], ch.ReturnPath, ch.Subject, text, html)
+}
@@ -0,0 +1,136 @@
+package qrapp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerdictPolicy_rejectReason(t *testing.T) {
+	verdicts := func(spam, virus, spf, dkim, dmarc string) *Message {
+		msg := &Message{}
+		msg.Receipt.SpamVerdict.Status = spam
+		msg.Receipt.VirusVerdict.Status = virus
+		msg.Receipt.SPFVerdict.Status = spf
+		msg.Receipt.DKIMVerdict.Status = dkim
+		msg.Receipt.DMARCVerdict.Status = dmarc
+		return msg
+	}
+	strict := &VerdictPolicy{RejectSpam: true, RejectVirus: true, RequireSPFOrDKIM: true, RequireDMARC: true}
+	tests := []struct {
+		name   string
+		policy *VerdictPolicy
+		msg    *Message
+		want   string
+	}{
+		{
+			name:   "no policy",
+			policy: nil,
+			msg:    verdicts("FAIL", "FAIL", "FAIL", "FAIL", "FAIL"),
+			want:   "",
+		},
+		{
+			name:   "all pass",
+			policy: strict,
+			msg:    verdicts("PASS", "PASS", "PASS", "PASS", "PASS"),
+			want:   "",
+		},
+		{
+			name:   "scanning disabled",
+			policy: &VerdictPolicy{RejectSpam: true, RejectVirus: true},
+			msg:    verdicts("DISABLED", "DISABLED", "PASS", "GRAY", "GRAY"),
+			want:   "",
+		},
+		{
+			name:   "virus",
+			policy: strict,
+			msg:    verdicts("PASS", "FAIL", "PASS", "PASS", "PASS"),
+			want:   "virus verdict FAIL",
+		},
+		{
+			name:   "spam",
+			policy: strict,
+			msg:    verdicts("FAIL", "PASS", "PASS", "PASS", "PASS"),
+			want:   "spam verdict FAIL",
+		},
+		{
+			name:   "only DKIM pass",
+			policy: &VerdictPolicy{RequireSPFOrDKIM: true},
+			msg:    verdicts("PASS", "PASS", "FAIL", "PASS", "GRAY"),
+			want:   "",
+		},
+		{
+			name:   "spoofed",
+			policy: &VerdictPolicy{RequireSPFOrDKIM: true},
+			msg:    verdicts("PASS", "PASS", "FAIL", "GRAY", "FAIL"),
+			want:   "SPF verdict FAIL and DKIM verdict GRAY",
+		},
+		{
+			name:   "DMARC",
+			policy: strict,
+			msg:    verdicts("PASS", "PASS", "PASS", "PASS", "GRAY"),
+			want:   "DMARC verdict GRAY",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.rejectReason(tt.msg))
+		})
+	}
+}
+
+func Test_authenticatedSenders(t *testing.T) {
+	verdicts := func(spf, dkim, dmarc string) *Message {
+		msg := &Message{}
+		msg.Mail.CommonHeaders.ReturnPath = "bounces@lists.larix.cl"
+		msg.Mail.CommonHeaders.From = []string{"Jorge <jorge@larix.cl>"}
+		msg.Receipt.SPFVerdict.Status = spf
+		msg.Receipt.DKIMVerdict.Status = dkim
+		msg.Receipt.DMARCVerdict.Status = dmarc
+		return msg
+	}
+	assert.Empty(t, authenticatedSenders(verdicts("GRAY", "FAIL", "GRAY")))
+	// DKIM only vouches for the signing domain
+	assert.Empty(t, authenticatedSenders(verdicts("GRAY", "PASS", "GRAY")))
+	// SPF only vouches for the return path
+	assert.Equal(t, []string{"bounces@lists.larix.cl"}, authenticatedSenders(verdicts("PASS", "PASS", "GRAY")))
+	// DMARC vouches for the From address
+	assert.Equal(t, []string{"Jorge <jorge@larix.cl>"}, authenticatedSenders(verdicts("FAIL", "PASS", "PASS")))
+}
+
+func TestQRApp_HandlerRejectedVerdict(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification (spf PASS, dkim GRAY, dmarc GRAY)
+	msg, err := testingMsg("snsemail-no-attachment.json")
+	require.Nil(t, err)
+	expectedMessageID := msg.Mail.CommonHeaders.MessageID
+	expectedReturnPath := msg.Mail.CommonHeaders.ReturnPath
+	expectedSubject := msg.Mail.CommonHeaders.Subject
+	expectedEmailAddr := msg.Receipt.Recipients[0]
+
+	// mock email reply (the email isn't downloaded)
+	storage := &MockStorage{}
+	mailer := &MockMailer{}
+	mailer.On("SendReply", ctxMatcher, expectedMessageID, expectedEmailAddr, expectedReturnPath, expectedSubject,
+		"lo siento, no pude verificar que tu correo sea legítimo, así que no lo procesé.",
+		"<p>lo siento, no pude verificar que tu correo sea legítimo, así que no lo procesé.</p>").Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+		VerdictPolicy:  &VerdictPolicy{RequireDMARC: true, ReplyOnReject: true},
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
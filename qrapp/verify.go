@@ -0,0 +1,52 @@
+package qrapp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	"github.com/makiuchi-d/gozxing"
+	gozxingqr "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// verifyQR decodes the QR code PNG image in imgFile, checking it contains content.
+func verifyQR(imgFile, content string) error {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	qrImg, err := png.Decode(f)
+	if err != nil {
+		return err
+	}
+	// draw the QR code over a white background, so transparent pixels aren't read as black
+	img := image.NewRGBA(qrImg.Bounds())
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(img, img.Bounds(), qrImg, qrImg.Bounds().Min, draw.Over)
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return err
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+	result, err := gozxingqr.NewQRCodeReader().Decode(bmp, hints)
+	if err != nil {
+		return fmt.Errorf("couldn't read the QR code: %s", err)
+	}
+	if result.GetText() != content {
+		return fmt.Errorf("the QR code contains %q instead of %q", result.GetText(), content)
+	}
+	return nil
+}
+
+// fallback returns the options used when the QR code generated with these options can't be read: the highest error
+// correction level (and no halftone background image).
+func (o QROptions) fallback() QROptions {
+	o.ErrorCorrection = "H"
+	return o
+}
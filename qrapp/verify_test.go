@@ -0,0 +1,189 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_verifyQR(t *testing.T) {
+	t.Parallel()
+
+	q := &QRApp{}
+	dir := t.TempDir()
+	content := "http://qr.mydomain.com/menu.pdf"
+	tests := []struct {
+		name string
+		opts QROptions
+	}{
+		{name: "defaults"},
+		{name: "transparent circles", opts: QROptions{Shape: "circle", Transparent: newBool(true), Foreground: "#003366"}},
+		{name: "framed", opts: QROptions{Frame: newBool(true), ErrorCorrection: "L"}},
+	}
+	for _, tt := range tests {
+		imgFile := filepath.Join(dir, tt.name+".png")
+		err := q.generateQR(content, "menu.pdf", "", imgFile, tt.opts)
+		require.Nil(t, err)
+		assert.Nil(t, verifyQR(imgFile, content), tt.name)
+		assert.NotNil(t, verifyQR(imgFile, "http://qr.mydomain.com/other.pdf"), tt.name)
+	}
+	// a blank image
+	imgFile := filepath.Join(dir, "blank.png")
+	f, err := os.Create(imgFile)
+	require.Nil(t, err)
+	blank := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(blank, blank.Bounds(), image.White, image.Point{}, draw.Src)
+	require.Nil(t, png.Encode(f, blank))
+	require.Nil(t, f.Close())
+	assert.NotNil(t, verifyQR(imgFile, content))
+}
+
+func TestQROptions_fallback(t *testing.T) {
+	opts := QROptions{ErrorCorrection: "L", Foreground: "#003366", Frame: newBool(true)}
+	assert.Equal(t, QROptions{ErrorCorrection: "H", Foreground: "#003366", Frame: newBool(true)}, opts.fallback())
+}
+
+func TestQRApp_HandlerUnreadableQR(t *testing.T) {
+	t.Parallel()
+
+	// get testing mail notification, asking for white modules
+	msg, err := testingMsg("snsemail-with-attachment.json")
+	require.Nil(t, err)
+	msg.Mail.CommonHeaders.Subject = "qr color=#ffffff"
+	expectedEmailKey := msg.Receipt.Action.ObjectKey
+
+	// mock email downloading
+	storage := &MockStorage{}
+	emailFile, err := mfs.Open(expectedEmailKey)
+	require.Nil(t, err)
+	defer emailFile.Close()
+	storage.On("DownloadToTmpFile", ctxMatcher, msg.Receipt.Action.BucketName, expectedEmailKey).Return(emailFile, nil)
+	storage.On("RemoveTmpFile", ctxMatcher, emailFile).Return(nil)
+	// nothing is uploaded when the QR code (even with the fallback options) can't be read
+	filesBucket := "qr.mydomain.com"
+	// mock email reply, with the error
+	mailer := &MockMailer{}
+	expectedTxt := regexp.MustCompile(`^No pude generar el código QR de historia-social-el-circo.pdf: couldn't read the QR code: `)
+	mailer.On("SendReply", ctxMatcher, msg.Mail.CommonHeaders.MessageID, msg.Receipt.Recipients[0],
+		msg.Mail.CommonHeaders.ReturnPath, "qr color=#ffffff", mock.MatchedBy(expectedTxt.MatchString), mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessEmail(context.Background(), msg)
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+// checkerboardPNG returns a checkerboard PNG image, a background image leaving the halftone QR codes unreadable.
+func checkerboardPNG(t *testing.T) *bytes.Buffer {
+	img := image.NewGray(image.Rect(0, 0, 200, 200))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 200; y++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.Black)
+			} else {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	b := &bytes.Buffer{}
+	require.Nil(t, png.Encode(b, img))
+	return b
+}
+
+func TestQRApp_ProcessRawEmailFallbackQR(t *testing.T) {
+	t.Parallel()
+
+	// email with a document and a background image leaving the halftone QR code unreadable
+	bkgPNG := checkerboardPNG(t)
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("menú").
+		Header("Message-Id", "<menu@larix.cl>").
+		Text([]byte("el menú con fondo")).
+		AddAttachment([]byte("%PDF-1.4 menú"), "application/pdf", "menu.pdf").
+		AddAttachment(bkgPNG.Bytes(), "image/png", "fondo.png").
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// mock attachment and qr uploading to files bucket, the qr generated with the fallback options can be read
+	storage := &MockStorage{}
+	filesBucket := "qr.mydomain.com"
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf", "application/pdf", mock.Anything).Return(nil)
+	readable := func(f *os.File) bool {
+		return verifyQR(f.Name(), "http://qr.mydomain.com/menu.pdf") == nil
+	}
+	storage.On("Upload", ctxMatcher, filesBucket, "menu.pdf.qr.png", "image/png", mock.MatchedBy(readable)).Return(nil)
+	// mock email reply, telling about the fallback
+	mailer := &MockMailer{}
+	expectedTxt := "menu.pdf quedó en http://qr.mydomain.com/menu.pdf. El QR está en http://qr.mydomain.com/menu.pdf.qr.png." +
+		" Ojo: el QR no se podía leer, así que lo generé sin imagen de fondo y con más corrección de errores."
+	mailer.On("SendReply", ctxMatcher, "<menu@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "menú", expectedTxt,
+		mock.Anything).Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    filesBucket,
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+}
+
+func TestQRApp_generateVerifiedQRFallback(t *testing.T) {
+	t.Parallel()
+
+	q := &QRApp{}
+	dir := t.TempDir()
+	content := "http://qr.mydomain.com/menu.pdf"
+	bkgImg := filepath.Join(dir, "bkg.png")
+	require.Nil(t, os.WriteFile(bkgImg, checkerboardPNG(t).Bytes(), 0600))
+	outputImg := filepath.Join(dir, "qr.png")
+	require.Nil(t, q.generateQR(content, "menu.pdf", bkgImg, outputImg, QROptions{}))
+	require.NotNil(t, verifyQR(outputImg, content))
+
+	// generated again without the background image and with ecc H
+	fallback, err := q.generateVerifiedQR(content, "menu.pdf", bkgImg, outputImg, QROptions{})
+	require.Nil(t, err)
+	assert.True(t, fallback)
+	assert.Nil(t, verifyQR(outputImg, content))
+	expectedImg := filepath.Join(dir, "expected.png")
+	require.Nil(t, q.generateQR(content, "menu.pdf", "", expectedImg, QROptions{}.fallback()))
+	expected, err := os.ReadFile(expectedImg)
+	require.Nil(t, err)
+	actual, err := os.ReadFile(outputImg)
+	require.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,166 @@
+package qrapp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gosimple/slug"
+	"github.com/jhillyerd/enmime"
+)
+
+// WiFiNetwork is a Wi-Fi network, written in the body of the emails as "key: value" lines:
+//
+//	ssid: Casa
+//	password: secreto
+//	security: WPA
+//	hidden: no
+type WiFiNetwork struct {
+	SSID     string
+	Password string
+	// Security is the authentication type: WPA, WEP or nopass.
+	Security string
+	Hidden   bool
+}
+
+// WiFiResult is the result of generating the QR code of a Wi-Fi network.
+type WiFiResult struct {
+	SSID  string
+	Error error
+}
+
+// wifiSecurities maps the accepted security values to the authentication types of the payload.
+var wifiSecurities = map[string]string{
+	"wpa":    "WPA",
+	"wpa2":   "WPA",
+	"wpa3":   "WPA",
+	"wep":    "WEP",
+	"nopass": "nopass",
+	"none":   "nopass",
+	"open":   "nopass",
+}
+
+// parseWiFiNetwork returns the Wi-Fi network described in the text body of an email, or nil if there isn't a ssid
+// line. Invalid values are reported as warnings, using the defaults instead. Like with the URLs, the signature and
+// quoted lines are ignored (see bodyLines).
+func parseWiFiNetwork(text string) (*WiFiNetwork, []string) {
+	network := &WiFiNetwork{}
+	var security, hidden string
+	for _, line := range bodyLines(text) {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "ssid":
+			network.SSID = value
+		case "password":
+			network.Password = value
+		case "security":
+			security = value
+		case "hidden":
+			hidden = value
+		}
+	}
+	if network.SSID == "" {
+		return nil, nil
+	}
+	var warnings []string
+	switch {
+	case security == "" && network.Password == "":
+		network.Security = "nopass"
+	case security == "":
+		network.Security = "WPA"
+	default:
+		var ok bool
+		network.Security, ok = wifiSecurities[strings.ToLower(security)]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("el valor de security debe ser WPA, WEP o none (%s)", security))
+			network.Security = "WPA"
+		}
+	}
+	if network.Security == "nopass" && network.Password != "" {
+		warnings = append(warnings, "la red no tiene contraseña, ignoré el valor de password")
+		network.Password = ""
+	}
+	switch strings.ToLower(hidden) {
+	case "", "no", "false":
+	case "si", "sí", "yes", "true":
+		network.Hidden = true
+	default:
+		warnings = append(warnings, fmt.Sprintf("el valor de hidden debe ser sí o no (%s)", hidden))
+	}
+	return network, warnings
+}
+
+// payload returns the content of the QR code of the network, in the format read by the phone cameras:
+// WIFI:T:WPA;S:ssid;P:password;H:true;;
+func (n *WiFiNetwork) payload() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "WIFI:T:%s;S:%s;", n.Security, escapeWiFi(n.SSID))
+	if n.Security != "nopass" {
+		fmt.Fprintf(sb, "P:%s;", escapeWiFi(n.Password))
+	}
+	if n.Hidden {
+		sb.WriteString("H:true;")
+	}
+	sb.WriteString(";")
+	return sb.String()
+}
+
+var wifiEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+
+// escapeWiFi escapes the special characters of the payload values.
+func escapeWiFi(s string) string {
+	return wifiEscaper.Replace(s)
+}
+
+// processWiFiBody generates the QR code of the Wi-Fi network described in the body of the email, returning false if
+// there's none.
+func (q *QRApp) processWiFiBody(ctx context.Context, req *request, envelope *enmime.Envelope) (bool, error) {
+	network, warnings := parseWiFiNetwork(envelope.Text)
+	if network == nil {
+		return false, nil
+	}
+	req.warnings = append(req.warnings, warnings...)
+	return true, q.processWiFiNetwork(ctx, req, network)
+}
+
+// processWiFiNetwork generates the QR code of the network and replies to the sender with it as an attachment. Nothing
+// is published, as the QR code contains the password.
+func (q *QRApp) processWiFiNetwork(ctx context.Context, req *request, network *WiFiNetwork) error {
+	result := &WiFiResult{SSID: network.SSID}
+	var attachments []MailAttachment
+	qrImg, err := q.generateWiFiQR(network, req.options.QR)
+	if err != nil {
+		result.Error = err
+	} else {
+		attachments = append(attachments, MailAttachment{
+			FileName:    network.fileName() + ".qr." + req.options.QR.format(),
+			ContentType: req.options.QR.contentType(),
+			Content:     qrImg,
+		})
+	}
+	return q.reply(ctx, req, &replyData{Warnings: req.warnings, WiFi: result}, attachments)
+}
+
+// fileName returns the name of the QR code attachment, without extension: wifi-casa
+func (n *WiFiNetwork) fileName() string {
+	return strings.TrimSuffix("wifi-"+slug.Make(n.SSID), "-")
+}
+
+// generateWiFiQR returns the QR code image of the network.
+func (q *QRApp) generateWiFiQR(network *WiFiNetwork, opts QROptions) ([]byte, error) {
+	outputImg, err := tmpFileName("wifi*." + opts.format())
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(outputImg)
+	_, err = q.generateVerifiedQR(network.payload(), network.SSID, "", outputImg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(outputImg)
+}
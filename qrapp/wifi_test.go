@@ -0,0 +1,114 @@
+package qrapp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseWiFiNetwork(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantPayload  string
+		wantWarnings []string
+	}{
+		{
+			name:        "WPA",
+			text:        "hola\r\nssid: Casa\r\npassword: secreto\r\n",
+			wantPayload: "WIFI:T:WPA;S:Casa;P:secreto;;",
+		},
+		{
+			name:        "hidden WEP network with special characters",
+			text:        "SSID: Casa; 2.4\"GHz\"\r\nPassword: a:b,c\\d\r\nSecurity: wep\r\nHidden: sí\r\n",
+			wantPayload: `WIFI:T:WEP;S:Casa\; 2.4\"GHz\";P:a\:b\,c\\d;H:true;;`,
+		},
+		{
+			name:        "open network",
+			text:        "ssid: Invitados\r\n",
+			wantPayload: "WIFI:T:nopass;S:Invitados;;",
+		},
+		{
+			name:         "invalid values",
+			text:         "ssid: Casa\r\npassword: secreto\r\nsecurity: wpa4\r\nhidden: tal vez\r\n",
+			wantPayload:  "WIFI:T:WPA;S:Casa;P:secreto;;",
+			wantWarnings: []string{"el valor de security debe ser WPA, WEP o none (wpa4)", "el valor de hidden debe ser sí o no (tal vez)"},
+		},
+		{
+			name:         "password of an open network",
+			text:         "ssid: Casa\r\npassword: secreto\r\nsecurity: none\r\n",
+			wantPayload:  "WIFI:T:nopass;S:Casa;;",
+			wantWarnings: []string{"la red no tiene contraseña, ignoré el valor de password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, warnings := parseWiFiNetwork(tt.text)
+			require.NotNil(t, network)
+			assert.Equal(t, tt.wantPayload, network.payload())
+			assert.Equal(t, tt.wantWarnings, warnings)
+		})
+	}
+	// without ssid (or in the signature), it isn't a Wi-Fi network
+	network, _ := parseWiFiNetwork("hola\r\npassword: secreto\r\n-- \r\nssid: Casa\r\n")
+	assert.Nil(t, network)
+}
+
+func TestQRApp_ProcessRawEmailWithWiFiNetwork(t *testing.T) {
+	t.Parallel()
+
+	// email without attachments, describing a Wi-Fi network
+	part, err := enmime.Builder().
+		From("Jorge", "jorge@larix.cl").
+		To("QR", "qr@mydomain.com").
+		Subject("wifi").
+		Header("Message-Id", "<wifi@larix.cl>").
+		Text([]byte("ssid: Casa Larix\r\npassword: secreto\r\nsecurity: WPA\r\nhidden: no\r\n")).
+		Build()
+	require.Nil(t, err)
+	email := &bytes.Buffer{}
+	require.Nil(t, part.Encode(email))
+
+	// nothing is published, the QR code is attached to the reply
+	storage := &MockStorage{}
+	mailer := &MockMailer{}
+	expectedTxt := "Te adjunto el código QR de la red Wi-Fi Casa Larix, no lo publiqué en ninguna parte."
+	var attachments []MailAttachment
+	mailer.On("SendReplyWithAttachments", ctxMatcher, "<wifi@larix.cl>", "qr@mydomain.com", "jorge@larix.cl", "wifi",
+		expectedTxt, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			attachments = args.Get(7).([]MailAttachment)
+		}).
+		Return(nil)
+
+	// SUT
+	q := &QRApp{
+		Storage:        storage,
+		Mailer:         mailer,
+		FilesBucket:    "qr.mydomain.com",
+		FilesBucketURL: "http://qr.mydomain.com",
+	}
+	// test
+	err = q.ProcessRawEmail(context.Background(), email, &EmailMetadata{
+		ReplyFrom:  "qr@mydomain.com",
+		ReturnPath: "jorge@larix.cl",
+	})
+	assert.Nil(t, err)
+
+	// check mocks
+	mock.AssertExpectationsForObjects(t, storage, mailer)
+	// check the attached QR code
+	require.Len(t, attachments, 1)
+	assert.Equal(t, "wifi-casa-larix.qr.png", attachments[0].FileName)
+	assert.Equal(t, "image/png", attachments[0].ContentType)
+	qrImg := filepath.Join(t.TempDir(), attachments[0].FileName)
+	require.Nil(t, os.WriteFile(qrImg, attachments[0].Content, 0600))
+	assert.Nil(t, verifyQR(qrImg, "WIFI:T:WPA;S:Casa Larix;P:secreto;;"))
+}